@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func cleanupCommand() *cobra.Command {
+	var vmSpecPath string
+	var startVM int
+	var nrVMs int
+	var outDir string
+	var nrNetworks int
+	var instance string
+
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove VMs, networks and images left behind by vmshed",
+		Long: `Remove VMs, networks and images left behind by vmshed.
+
+This is useful after vmshed was killed or when '--on-failure keep-vms'
+was used. The VMs with IDs in the range given by --startvm and --nvms
+are removed. The instance, the range of VM IDs, the networks and the VMs
+that were provisioning images are taken from the state file in --out-dir,
+unless given as flags. If there is no state file, the networks that vmshed would create with
+the first --networks numbers are removed. The provisioned images for
+the VMs in the VM specification are removed.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			saved, err := loadState(outDir)
+			if errors.Is(err, os.ErrNotExist) {
+				saved = nil
+			} else if err != nil {
+				log.Fatal(err)
+			}
+
+			if saved != nil && saved.StartVM > 0 {
+				// Use the instance and VM IDs recorded by the run
+				if !cmd.Flags().Changed("instance") {
+					instance = saved.Instance
+				}
+				if !cmd.Flags().Changed("startvm") {
+					startVM = saved.StartVM
+				}
+				if !cmd.Flags().Changed("nvms") {
+					nrVMs = saved.NrVMs
+				}
+			}
+
+			if startVM <= 0 {
+				log.Fatal("--startvm has to be positive")
+			}
+			if nrVMs <= 0 {
+				log.Fatal("--nvms has to be positive")
+			}
+
+			if err := validateInstanceName(instance); err != nil {
+				log.Fatal(err)
+			}
+
+			var vmSpec vmSpecification
+			if _, err := toml.DecodeFile(vmSpecPath, &vmSpec); err != nil {
+				log.Fatal(err)
+			}
+			vmSpec.instance = instance
+
+			errs := cleanup(outDir, instance, &vmSpec, startVM, nrVMs, nrNetworks, saved)
+			if len(errs) > 0 {
+				log.Warnln("ERROR: Could not clean up everything")
+				for i, err := range errs {
+					log.Warnf("ERROR %d: %s", i, err)
+				}
+				os.Exit(1)
+			}
+			log.Infoln("STATUS: Cleanup done")
+		},
+	}
+
+	cleanupCmd.Flags().StringVarP(&vmSpecPath, "vms", "", "vms.toml", "File containing VM specification")
+	cleanupCmd.Flags().IntVarP(&startVM, "startvm", "", 2, "Number of the first VM to remove")
+	cleanupCmd.Flags().IntVarP(&nrVMs, "nvms", "", 12, "Number of VMs to remove, starting at --startvm")
+	cleanupCmd.Flags().StringVarP(&outDir, "out-dir", "", "tests-out", "Directory containing the state file; logs of the cleanup are written here")
+	cleanupCmd.Flags().IntVarP(&nrNetworks, "networks", "", 8, "Number of access and extra networks to remove if there is no state file")
+	cleanupCmd.Flags().StringVar(&instance, "instance", "", "Namespace of the vmshed instance to clean up")
+	return cleanupCmd
+}
+
+// cleanupNetworkNames returns the names of the networks to remove. They are
+// taken from the state file, if there is one.
+func cleanupNetworkNames(instance string, nrNetworks int, saved *savedState) []string {
+	if saved != nil {
+		return saved.Networks
+	}
+
+	networkNames := []string{}
+	for i := 0; i < nrNetworks; i++ {
+		networkNames = append(networkNames, generateNetworkName(instance, i, true), generateNetworkName(instance, i, false))
+	}
+	return networkNames
+}
+
+// cleanup removes the VMs, networks and images. The state file, if any, is
+// used to find the networks and the VMs used for provisioning. Failures to
+// remove networks are only reported if the networks are known to exist.
+func cleanup(outDir string, instance string, vmSpec *vmSpecification, startVM int, nrVMs int, nrNetworks int, saved *savedState) []error {
+	var errs []error
+
+	if saved == nil {
+		log.Infof("No state file in %s, trying the first %d networks", outDir, nrNetworks)
+	}
+
+	logDir := filepath.Join(outDir, "cleanup-log")
+	for i := 0; i < nrVMs; i++ {
+		vm := vmInstance{instance: instance, nr: startVM + i}
+		if err := removeVM(log.StandardLogger(), logDir, vm); err != nil {
+			dumpStderr(log.StandardLogger(), err)
+			errs = append(errs, fmt.Errorf("remove VM %s: %w", vm.vmName(), err))
+		}
+	}
+
+	if saved != nil {
+		for _, p := range saved.Provisioning {
+			if err := removeVMByName(log.StandardLogger(), logDir, p.Image, p.Network); err != nil {
+				dumpStderr(log.StandardLogger(), err)
+				errs = append(errs, fmt.Errorf("remove VM %s: %w", p.Image, err))
+			}
+		}
+	}
+
+	for _, networkName := range cleanupNetworkNames(instance, nrNetworks, saved) {
+		if err := removeNetwork(outDir, networkName); err != nil && saved != nil {
+			errs = append(errs, fmt.Errorf("remove network %s: %w", networkName, err))
+		}
+	}
+
+	errs = append(errs, removeImages(outDir, vmSpec)...)
+	return errs
+}
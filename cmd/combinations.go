@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+const (
+	combinationsRandom   = "random"
+	combinationsPairwise = "pairwise"
+	combinationsAll      = "all"
+)
+
+// maxVMCombinations limits the number of runs that combinations = "all" may
+// define for one test, VM count and variant.
+const maxVMCombinations = 1000
+
+// vmCombinations returns the VMs for each run of a test according to the
+// "combinations" key of the test.
+func vmCombinations(randomGenerator *rand.Rand, combinations string, vms []vm, vmCount int) ([][]vm, error) {
+	switch combinations {
+	case combinationsPairwise:
+		return pairwiseVMCombinations(randomGenerator, vms, vmCount), nil
+	case combinationsAll:
+		if n := countVMCombinations(len(vms), vmCount); n > maxVMCombinations {
+			return nil, fmt.Errorf("combinations %q with %d VMs out of %d base images would define more than %d runs, use %q instead",
+				combinationsAll, vmCount, len(vms), maxVMCombinations, combinationsPairwise)
+		}
+		return allVMCombinations(vms, vmCount), nil
+	default:
+		return nil, fmt.Errorf("unknown combinations %q, should be one out of %q %q %q",
+			combinations, combinationsRandom, combinationsPairwise, combinationsAll)
+	}
+}
+
+// countVMCombinations returns the size of the Cartesian product of nVMs VMs
+// for vmCount positions, or maxVMCombinations+1 if it is larger.
+func countVMCombinations(nVMs int, vmCount int) int {
+	n := 1
+	for i := 0; i < vmCount; i++ {
+		n *= nVMs
+		if n > maxVMCombinations {
+			return maxVMCombinations + 1
+		}
+	}
+	return n
+}
+
+// allVMCombinations returns the Cartesian product of the VMs for vmCount
+// positions.
+func allVMCombinations(vms []vm, vmCount int) [][]vm {
+	combinations := [][]vm{{}}
+	for i := 0; i < vmCount; i++ {
+		next := [][]vm{}
+		for _, combination := range combinations {
+			for _, v := range vms {
+				next = append(next, append(slices.Clone(combination), v))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// vmPair is a pair of VM indices at a pair of positions in a combination.
+// posA is always less than posB.
+type vmPair struct {
+	posA int
+	vmA  int
+	posB int
+	vmB  int
+}
+
+func makeVMPair(posA, vmA, posB, vmB int) vmPair {
+	if posA > posB {
+		return vmPair{posA: posB, vmA: vmB, posB: posA, vmB: vmA}
+	}
+	return vmPair{posA: posA, vmA: vmA, posB: posB, vmB: vmB}
+}
+
+// pairwiseVMCombinations returns combinations of the VMs for vmCount
+// positions such that every pair of VMs occurs at every pair of positions in
+// at least one combination. The combinations are built greedily, so the
+// result is small but not necessarily minimal.
+func pairwiseVMCombinations(randomGenerator *rand.Rand, vms []vm, vmCount int) [][]vm {
+	if vmCount < 3 {
+		// Covering all pairs requires all combinations
+		return allVMCombinations(vms, vmCount)
+	}
+
+	// Iterate in a fixed order so that the result only depends on the
+	// random generator
+	var allPairs []vmPair
+	for posA := 0; posA < vmCount; posA++ {
+		for posB := posA + 1; posB < vmCount; posB++ {
+			for vmA := range vms {
+				for vmB := range vms {
+					allPairs = append(allPairs, vmPair{posA: posA, vmA: vmA, posB: posB, vmB: vmB})
+				}
+			}
+		}
+	}
+
+	uncovered := make(map[vmPair]bool, len(allPairs))
+	for _, pair := range allPairs {
+		uncovered[pair] = true
+	}
+
+	combinations := [][]vm{}
+	for _, pair := range allPairs {
+		if !uncovered[pair] {
+			continue
+		}
+
+		// Start from an uncovered pair so that each combination
+		// covers at least one new pair
+		choice := make([]int, vmCount)
+		for i := range choice {
+			choice[i] = -1
+		}
+		choice[pair.posA] = pair.vmA
+		choice[pair.posB] = pair.vmB
+
+		for _, pos := range randomGenerator.Perm(vmCount) {
+			if choice[pos] >= 0 {
+				continue
+			}
+
+			best := -1
+			bestCount := -1
+			for _, candidate := range randomGenerator.Perm(len(vms)) {
+				count := 0
+				for otherPos, otherVM := range choice {
+					if otherVM >= 0 && uncovered[makeVMPair(pos, candidate, otherPos, otherVM)] {
+						count++
+					}
+				}
+				if count > bestCount {
+					best = candidate
+					bestCount = count
+				}
+			}
+			choice[pos] = best
+		}
+
+		combination := make([]vm, vmCount)
+		for posA, vmA := range choice {
+			combination[posA] = vms[vmA]
+			for posB := posA + 1; posB < vmCount; posB++ {
+				delete(uncovered, makeVMPair(posA, vmA, posB, choice[posB]))
+			}
+		}
+		combinations = append(combinations, combination)
+	}
+
+	return combinations
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func combinationIDs(combinations [][]vm) [][]string {
+	ids := [][]string{}
+	for _, combination := range combinations {
+		ids = append(ids, baseImageNames(combination))
+	}
+	return ids
+}
+
+func TestAllVMCombinations(t *testing.T) {
+	vms := []vm{{BaseImage: "a"}, {BaseImage: "b"}}
+
+	assert.Equal(t, [][]string{{"a"}, {"b"}}, combinationIDs(allVMCombinations(vms, 1)))
+	assert.Equal(t, [][]string{
+		{"a", "a", "a"}, {"a", "a", "b"}, {"a", "b", "a"}, {"a", "b", "b"},
+		{"b", "a", "a"}, {"b", "a", "b"}, {"b", "b", "a"}, {"b", "b", "b"},
+	}, combinationIDs(allVMCombinations(vms, 3)))
+}
+
+func TestVMCombinationsLimit(t *testing.T) {
+	vms := make([]vm, 10)
+	for i := range vms {
+		vms[i] = vm{BaseImage: fmt.Sprintf("image-%d", i)}
+	}
+
+	combinations, err := vmCombinations(nil, combinationsAll, vms, 3)
+	require.NoError(t, err)
+	assert.Len(t, combinations, 1000)
+
+	_, err = vmCombinations(nil, combinationsAll, vms, 4)
+	assert.Error(t, err)
+	assert.Equal(t, maxVMCombinations+1, countVMCombinations(10, 100))
+}
+
+func TestPairwiseVMCombinations(t *testing.T) {
+	vms := []vm{{BaseImage: "a"}, {BaseImage: "b"}, {BaseImage: "c"}}
+
+	for _, vmCount := range []int{1, 2, 3, 4} {
+		combinations := pairwiseVMCombinations(rand.New(rand.NewSource(1)), vms, vmCount)
+
+		if vmCount >= 3 {
+			assert.Less(t, len(combinations), len(allVMCombinations(vms, vmCount)),
+				"pairwise with %d VMs should need fewer runs than all combinations", vmCount)
+		}
+
+		covered := map[vmPair]bool{}
+		for _, combination := range combinations {
+			require.Len(t, combination, vmCount)
+			for posA := range combination {
+				for posB := posA + 1; posB < vmCount; posB++ {
+					covered[makeVMPair(posA, indexOfVM(vms, combination[posA]), posB, indexOfVM(vms, combination[posB]))] = true
+				}
+			}
+		}
+
+		for posA := 0; posA < vmCount; posA++ {
+			for posB := posA + 1; posB < vmCount; posB++ {
+				for vmA := range vms {
+					for vmB := range vms {
+						assert.True(t, covered[vmPair{posA: posA, vmA: vmA, posB: posB, vmB: vmB}],
+							"pair %s/%s at positions %d/%d not covered", vms[vmA].ID(), vms[vmB].ID(), posA, posB)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestVMCombinationsUnknown(t *testing.T) {
+	_, err := vmCombinations(rand.New(rand.NewSource(1)), "some", []vm{{BaseImage: "a"}}, 2)
+	assert.Error(t, err)
+}
+
+func indexOfVM(vms []vm, v vm) int {
+	for i := range vms {
+		if vms[i].ID() == v.ID() {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func compareCommand() *cobra.Command {
+	var threshold float64
+	var minChange time.Duration
+
+	compareCmd := &cobra.Command{
+		Use:   "compare OLD_RESULTS NEW_RESULTS",
+		Short: "Compare the results.json files of two test suite runs",
+		Long: `Compare the results.json files of two test suite runs.
+
+Runs are matched by test name, variant and base images. New failures,
+fixed tests, tests which are skipped in the new results but were run
+before, tests which are missing from the new results and significant
+changes of the duration are reported. The exit code is 1 if there are new
+failures.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			oldResults, err := loadResultsJSON(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			newResults, err := loadResultsJSON(args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			c := compareResults(oldResults, newResults, threshold, minChange)
+			c.print(os.Stdout)
+			if len(c.newFailures) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	compareCmd.Flags().Float64Var(&threshold, "duration-threshold", 0.5, "Relative change of the mean duration that is reported, 0.5 means 50%")
+	compareCmd.Flags().DurationVar(&minChange, "duration-min-change", time.Minute, "Minimum absolute change of the mean duration that is reported")
+	return compareCmd
+}
+
+// runKey identifies equivalent runs in different test suite runs.
+type runKey struct {
+	name       string
+	variant    string
+	baseImages string
+}
+
+func makeRunKey(data resultData) runKey {
+	images := append([]string{}, data.BaseImages...)
+	sort.Strings(images)
+	return runKey{name: data.Name, variant: data.Variant, baseImages: strings.Join(images, ",")}
+}
+
+func (k runKey) String() string {
+	return fmt.Sprintf("%s variant:%s images:%s", k.name, k.variant, k.baseImages)
+}
+
+// runSummary aggregates the runs with the same key. Skipped runs are only
+// counted in skipped.
+type runSummary struct {
+	runs      int
+	failures  int
+	skipped   int
+	totalTime time.Duration
+}
+
+func (s runSummary) failed() bool {
+	return s.failures > 0
+}
+
+func (s runSummary) meanDuration() time.Duration {
+	return s.totalTime / time.Duration(s.runs)
+}
+
+type durationChange struct {
+	key      runKey
+	old, new time.Duration
+}
+
+type comparison struct {
+	newFailures     []runKey
+	fixed           []runKey
+	newlySkipped    []runKey
+	notRun          []runKey
+	durationChanges []durationChange
+	unmatched       int
+}
+
+func summarizeResults(results []resultData) map[runKey]runSummary {
+	summaries := map[runKey]runSummary{}
+	for _, data := range results {
+		status := TestStatus(data.Status)
+		if status == StatusCanceled || status == StatusXFail {
+			// expected failures are neither regressions nor fixes
+			continue
+		}
+
+		key := makeRunKey(data)
+		s := summaries[key]
+		if status == StatusSkipped {
+			s.skipped++
+			summaries[key] = s
+			continue
+		}
+
+		s.runs++
+		s.totalTime += time.Duration(data.DurationNS)
+		if statusScore(status) == 0 {
+			s.failures++
+		}
+		summaries[key] = s
+	}
+	return summaries
+}
+
+func compareResults(oldResults, newResults []resultData, threshold float64, minChange time.Duration) comparison {
+	oldSummaries := summarizeResults(oldResults)
+	newSummaries := summarizeResults(newResults)
+
+	var c comparison
+	for key, n := range newSummaries {
+		o, ok := oldSummaries[key]
+		if n.runs == 0 {
+			// all runs were skipped
+			if ok && o.runs > 0 {
+				c.newlySkipped = append(c.newlySkipped, key)
+			}
+			continue
+		}
+		if !ok || o.runs == 0 {
+			c.unmatched++
+			continue
+		}
+
+		if n.failed() && !o.failed() {
+			c.newFailures = append(c.newFailures, key)
+		} else if !n.failed() && o.failed() {
+			c.fixed = append(c.fixed, key)
+		}
+
+		oldDuration, newDuration := o.meanDuration(), n.meanDuration()
+		diff := (newDuration - oldDuration).Abs()
+		if oldDuration > 0 && diff >= minChange && float64(diff)/float64(oldDuration) >= threshold {
+			c.durationChanges = append(c.durationChanges, durationChange{key: key, old: oldDuration, new: newDuration})
+		}
+	}
+
+	for key, o := range oldSummaries {
+		if _, ok := newSummaries[key]; !ok && o.runs > 0 {
+			c.notRun = append(c.notRun, key)
+		}
+	}
+
+	sortKeys(c.newFailures)
+	sortKeys(c.fixed)
+	sortKeys(c.newlySkipped)
+	sortKeys(c.notRun)
+	sort.Slice(c.durationChanges, func(i, j int) bool {
+		return c.durationChanges[i].key.String() < c.durationChanges[j].key.String()
+	})
+	return c
+}
+
+func sortKeys(keys []runKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+}
+
+func (c comparison) print(w io.Writer) {
+	for _, key := range c.newFailures {
+		fmt.Fprintf(w, "NEW FAILURE: %s\n", key)
+	}
+	for _, key := range c.fixed {
+		fmt.Fprintf(w, "FIXED: %s\n", key)
+	}
+	for _, key := range c.newlySkipped {
+		fmt.Fprintf(w, "NEWLY SKIPPED: %s\n", key)
+	}
+	for _, key := range c.notRun {
+		fmt.Fprintf(w, "NOT RUN: %s\n", key)
+	}
+	for _, change := range c.durationChanges {
+		fmt.Fprintf(w, "DURATION: %s: %v -> %v\n", change.key,
+			change.old.Round(time.Second), change.new.Round(time.Second))
+	}
+	fmt.Fprintf(w, "SUMMARY: %d new failures, %d fixed, %d newly skipped, %d not run, %d duration changes, %d runs without match\n",
+		len(c.newFailures), len(c.fixed), len(c.newlySkipped), len(c.notRun), len(c.durationChanges), c.unmatched)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareResults(t *testing.T) {
+	result := func(name string, status TestStatus, duration time.Duration, images ...string) resultData {
+		return resultData{Name: name, Variant: "default", BaseImages: images, Status: string(status), DurationNS: int64(duration)}
+	}
+
+	oldResults := []resultData{
+		result("regressed", StatusSuccess, time.Minute, "a", "b"),
+		result("fixed", StatusFailed, time.Minute, "a"),
+		result("dropped", StatusSuccess, time.Minute, "a"),
+		result("disabled", StatusSuccess, time.Minute, "a"),
+		result("slower", StatusSuccess, 2*time.Minute, "a"),
+		result("noise", StatusSuccess, 10*time.Second, "a"),
+	}
+	newResults := []resultData{
+		// base images are matched regardless of their order
+		result("regressed", StatusSuccess, time.Minute, "b", "a"),
+		result("regressed", StatusFailedTimeout, time.Minute, "b", "a"),
+		result("fixed", StatusSuccess, time.Minute, "a"),
+		result("slower", StatusSuccess, 4*time.Minute, "a"),
+		result("noise", StatusSuccess, 30*time.Second, "a"),
+		result("disabled", StatusSkipped, 0, "a"),
+		result("other", StatusFailed, time.Minute, "c"),
+		// skipped runs without a match are not counted
+		result("new-disabled", StatusSkipped, 0, "c"),
+	}
+
+	c := compareResults(oldResults, newResults, 0.5, time.Minute)
+	assert.Equal(t, []runKey{{name: "regressed", variant: "default", baseImages: "a,b"}}, c.newFailures)
+	assert.Equal(t, []runKey{{name: "fixed", variant: "default", baseImages: "a"}}, c.fixed)
+	assert.Equal(t, []runKey{{name: "disabled", variant: "default", baseImages: "a"}}, c.newlySkipped)
+	assert.Equal(t, []runKey{{name: "dropped", variant: "default", baseImages: "a"}}, c.notRun)
+	assert.Equal(t, []durationChange{{
+		key: runKey{name: "slower", variant: "default", baseImages: "a"},
+		old: 2 * time.Minute,
+		new: 4 * time.Minute,
+	}}, c.durationChanges)
+	assert.Equal(t, 1, c.unmatched)
+
+	var out strings.Builder
+	c.print(&out)
+	assert.Contains(t, out.String(), "NEW FAILURE: regressed variant:default images:a,b\n")
+	assert.Contains(t, out.String(), "NEWLY SKIPPED: disabled variant:default images:a\n")
+	assert.Contains(t, out.String(), "NOT RUN: dropped variant:default images:a\n")
+	assert.Contains(t, out.String(), "DURATION: slower variant:default images:a: 2m0s -> 4m0s\n")
+}
@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const controlTimeout = 10 * time.Second
+
+// controlRequest is sent as JSON by a client of the control socket.
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// controlResponse is sent as JSON in reply to a controlRequest.
+type controlResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// controlMessage is a request passed to the scheduler together with the
+// channel for the response.
+type controlMessage struct {
+	request controlRequest
+	reply   chan controlResponse
+}
+
+// controlServer accepts requests on a Unix socket and passes them to the
+// scheduler.
+type controlServer struct {
+	path     string
+	listener net.Listener
+	commands chan controlMessage
+	done     chan struct{}
+}
+
+func startControlServer(path string) (*controlServer, error) {
+	// Remove a socket left behind by a previous run
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	s := &controlServer{
+		path:     path,
+		listener: listener,
+		commands: make(chan controlMessage),
+		done:     make(chan struct{}),
+	}
+	go s.serve()
+	log.Infof("CONTROL: Listening on %s", path)
+	return s, nil
+}
+
+func (s *controlServer) close() {
+	close(s.done)
+	s.listener.Close()
+	os.Remove(s.path)
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				log.Warnf("CONTROL: Failed to accept connection: %v", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var request controlRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		json.NewEncoder(conn).Encode(controlResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	response := s.dispatch(request)
+	json.NewEncoder(conn).Encode(response)
+}
+
+func (s *controlServer) dispatch(request controlRequest) controlResponse {
+	command := controlMessage{request: request, reply: make(chan controlResponse, 1)}
+	timeout := time.After(controlTimeout)
+
+	select {
+	case s.commands <- command:
+	case <-s.done:
+		return controlResponse{Error: "test suite run is finishing"}
+	case <-timeout:
+		return controlResponse{Error: "scheduler is not accepting commands"}
+	}
+
+	select {
+	case response := <-command.reply:
+		return response
+	case <-timeout:
+		return controlResponse{Error: "timeout waiting for the scheduler"}
+	}
+}
+
+// handleControlCommand carries out a request. It is called from the
+// scheduler loop, so that the state can be accessed safely.
+func handleControlCommand(suiteRun *testSuiteRun, state *suiteState, request controlRequest, stopped *bool) controlResponse {
+	log.Infof("CONTROL: %s %s", request.Command, strings.Join(request.Args, " "))
+
+	var output string
+	var err error
+	switch request.Command {
+	case "list":
+		output = listRuns(suiteRun, state)
+	case "cancel":
+		output, err = cancelRun(suiteRun, state, request.Args)
+	case "stop":
+		if !*stopped {
+			*stopped = true
+			log.Infof("STATUS: Stop requested, no new tests will be scheduled")
+		}
+		output = "no new tests will be scheduled"
+	case "repeat":
+		output, err = addRepeats(suiteRun, state, request.Args, *stopped)
+	case "nvms":
+		output, err = setNVMs(suiteRun, state, request.Args)
+	case "resume":
+		output, err = resumeRuns(suiteRun, request.Args)
+	default:
+		err = fmt.Errorf("unknown command %q", request.Command)
+	}
+
+	if err != nil {
+		return controlResponse{Error: err.Error()}
+	}
+	return controlResponse{Output: output}
+}
+
+func listRuns(suiteRun *testSuiteRun, state *suiteState) string {
+	paused := map[string]bool{}
+	if suiteRun.pauses != nil {
+		for _, id := range suiteRun.pauses.pausedRuns() {
+			paused[id] = true
+		}
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTAGE\tSTATUS\tIDS\tELAPSED")
+	for _, run := range suiteRun.testRuns {
+		stage := state.runStage[run.testID]
+		status := ""
+		if res, ok := state.runResults[run.testID]; ok {
+			status = string(res.status)
+		} else if paused[run.testID] {
+			status = "PAUSED"
+		}
+
+		ids := ""
+		elapsed := ""
+		if resources, ok := state.runResources[run.testID]; ok {
+			ids = strings.Trim(fmt.Sprint(resources.ids), "[]")
+			elapsed = time.Since(resources.start).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", run.testID, stage, status, ids, elapsed)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "Using %d of %d VM IDs, %d free\n", state.nvms, suiteRun.nrVMs, max(availableIDs(suiteRun, state), 0))
+	return b.String()
+}
+
+func cancelRun(suiteRun *testSuiteRun, state *suiteState, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: cancel RUN")
+	}
+	testID := args[0]
+
+	switch state.runStage[testID] {
+	case runNew:
+		state.runStage[testID] = runDone
+		state.runResults[testID] = notStartedResult("not started: canceled by control command")
+		return fmt.Sprintf("%s will not be started", testID), nil
+	case runExec:
+		state.runCancel[testID](errCanceledByControl)
+		return fmt.Sprintf("canceling %s", testID), nil
+	case runDone:
+		return "", fmt.Errorf("%s has already finished", testID)
+	default:
+		return "", fmt.Errorf("unknown run %s", testID)
+	}
+}
+
+func addRepeats(suiteRun *testSuiteRun, state *suiteState, args []string, stopped bool) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", errors.New("usage: repeat TEST [COUNT]")
+	}
+	if stopped {
+		return "", errors.New("no new tests are being scheduled")
+	}
+
+	count := 1
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.Atoi(args[1])
+		if err != nil || count < 1 {
+			return "", fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+
+	var template *testRun
+	for i, run := range suiteRun.testRuns {
+		if run.testName == args[0] && run.disabled == "" {
+			template = &suiteRun.testRuns[i]
+			break
+		}
+	}
+	if template == nil {
+		return "", fmt.Errorf("test %s has no runs", args[0])
+	}
+
+	added := []string{}
+	for i := 0; i < count; i++ {
+		run, err := repeatTestRun(suiteRun.randomGenerator, suiteRun.testRuns, template)
+		if err != nil {
+			return "", err
+		}
+
+		log.Debugf("SCHEDULE: Add run %s", run.testID)
+		suiteRun.testRuns = append(suiteRun.testRuns, run)
+		state.runStage[run.testID] = runNew
+		added = append(added, run.testID)
+		// appending may have moved the runs
+		template = &suiteRun.testRuns[len(suiteRun.testRuns)-1]
+	}
+	return "added " + strings.Join(added, ", "), nil
+}
+
+func setNVMs(suiteRun *testSuiteRun, state *suiteState, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: nvms COUNT")
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > suiteRun.nrVMs {
+		return "", fmt.Errorf("number of VMs must be between 1 and --nvms %d", suiteRun.nrVMs)
+	}
+
+	state.nvms = n
+	log.Infof("STATUS: Using up to %d VMs in parallel", n)
+	return fmt.Sprintf("using up to %d VMs in parallel", n), nil
+}
+
+func resumeRuns(suiteRun *testSuiteRun, args []string) (string, error) {
+	if suiteRun.pauses == nil {
+		return "", errors.New("--pause-on-failure is not enabled")
+	}
+	if len(args) > 1 {
+		return "", errors.New("usage: resume [RUN]")
+	}
+
+	testID := ""
+	if len(args) == 1 {
+		testID = args[0]
+	}
+
+	resumed := suiteRun.pauses.resume(testID)
+	if len(resumed) == 0 {
+		return "", errors.New("no matching paused runs")
+	}
+	log.Infof("PAUSE: Resuming %s", strings.Join(resumed, ", "))
+	return "resumed " + strings.Join(resumed, ", "), nil
+}
+
+// sendControlRequest sends a request to the control socket and returns the
+// response.
+func sendControlRequest(path string, request controlRequest) (controlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return controlResponse{}, fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return controlResponse{}, err
+	}
+
+	var response controlResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return controlResponse{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	return response, nil
+}
+
+func controlCommand() *cobra.Command {
+	var socketPath string
+
+	controlCmd := &cobra.Command{
+		Use:   "control COMMAND [ARGS...]",
+		Short: "Manage a running test suite run through its control socket",
+		Long: `Manage a running test suite run through its control socket.
+
+The test suite run must have been started with --control-socket. Commands:
+
+  list                 List the runs with their stage and status
+  cancel RUN           Cancel a run, whether it is running or not yet started
+  stop                 Stop scheduling new runs, running tests finish
+  repeat TEST [COUNT]  Add COUNT more runs of a test, 1 by default
+  nvms COUNT           Change the number of VMs used in parallel, at most --nvms
+  resume [RUN]         Resume a run paused by --pause-on-failure, all by default`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			response, err := sendControlRequest(socketPath, controlRequest{Command: args[0], Args: args[1:]})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if response.Error != "" {
+				log.Fatal(response.Error)
+			}
+			fmt.Print(response.Output)
+			if response.Output != "" && !strings.HasSuffix(response.Output, "\n") {
+				fmt.Println()
+			}
+		},
+	}
+
+	controlCmd.Flags().StringVar(&socketPath, "socket", "", "Path of the control socket given to --control-socket")
+	controlCmd.MarkFlagRequired("socket")
+	return controlCmd
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"math/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleControlCommand(t *testing.T) {
+	_, baseNet, err := net.ParseCIDR("10.224.0.0/24")
+	require.NoError(t, err)
+
+	vm0 := vm{BaseImage: "b0"}
+	config := &testConfig{testName: "t", vmSpec: &vmSpecification{VMs: []vm{vm0}}, repeats: 1}
+	suiteRun := &testSuiteRun{
+		vmSpec: config.vmSpec,
+		testRuns: []testRun{
+			{testName: "t", testID: "t-1-default-0", vms: []vm{vm0}, variant: variant{Name: "default"}, config: config},
+			{testName: "t", testID: "t-1-default-1", vms: []vm{vm0}, variant: variant{Name: "default"}, config: config},
+		},
+		startVM:         5,
+		nrVMs:           2,
+		firstV4Net:      baseNet,
+		randomGenerator: rand.New(rand.NewSource(1)),
+	}
+	state := initializeState(suiteRun)
+	stopped := false
+
+	command := func(name string, args ...string) controlResponse {
+		return handleControlCommand(suiteRun, state, controlRequest{Command: name, Args: args}, &stopped)
+	}
+
+	response := command("cancel", "t-1-default-1")
+	assert.Empty(t, response.Error)
+	assert.Equal(t, runDone, state.runStage["t-1-default-1"])
+	assert.Equal(t, StatusSkipped, state.runResults["t-1-default-1"].status)
+	assert.Equal(t, OutcomeClass(""), resultOutcome(state.runResults["t-1-default-1"]),
+		"canceling a run that has not started does not affect the exit code")
+	assert.NotEmpty(t, command("cancel", "t-1-default-1").Error)
+	assert.NotEmpty(t, command("cancel", "unknown").Error)
+
+	response = command("repeat", "t", "2")
+	assert.Empty(t, response.Error)
+	require.Len(t, suiteRun.testRuns, 4)
+	assert.Equal(t, "t-1-default-2", suiteRun.testRuns[2].testID)
+	assert.Equal(t, "t-1-default-3", suiteRun.testRuns[3].testID)
+	assert.Equal(t, runNew, state.runStage["t-1-default-3"])
+	assert.NotEmpty(t, command("repeat", "other").Error)
+
+	assert.Equal(t, 2, availableIDs(suiteRun, state))
+	assert.Empty(t, command("nvms", "1").Error)
+	assert.Equal(t, 1, availableIDs(suiteRun, state))
+	assert.NotEmpty(t, command("nvms", "3").Error)
+
+	response = command("list")
+	assert.Empty(t, response.Error)
+	assert.Contains(t, response.Output, "t-1-default-1  Done   SKIPPED")
+	assert.Contains(t, response.Output, "Using 1 of 2 VM IDs")
+
+	assert.Empty(t, command("stop").Error)
+	assert.True(t, stopped)
+	assert.NotEmpty(t, command("repeat", "t").Error)
+
+	assert.NotEmpty(t, command("resume").Error)
+	assert.NotEmpty(t, command("unknown").Error)
+}
+
+func TestControlServer(t *testing.T) {
+	server, err := startControlServer(filepath.Join(t.TempDir(), "control.sock"))
+	require.NoError(t, err)
+
+	go func() {
+		for command := range server.commands {
+			command.reply <- controlResponse{Output: command.request.Command + " " + command.request.Args[0]}
+		}
+	}()
+
+	response, err := sendControlRequest(server.path, controlRequest{Command: "cancel", Args: []string{"x"}})
+	require.NoError(t, err)
+	assert.Equal(t, "cancel x", response.Output)
+
+	server.close()
+	_, err = sendControlRequest(server.path, controlRequest{Command: "list"})
+	assert.Error(t, err)
+}
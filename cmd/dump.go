@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const stateDumpTimeFormat = "20060102T150405"
+
+// stateSnapshot is a copy of the parts of the scheduler state that are shown
+// in a state dump.
+type stateSnapshot struct {
+	runs     []runSnapshot
+	freeIDs  []int
+	networks []networkSnapshot
+	images   []imageSnapshot
+	errors   []string
+}
+
+type runSnapshot struct {
+	testID    string
+	stage     runStage
+	status    TestStatus
+	resources *runResources // nil unless the run is in runExec
+}
+
+type networkSnapshot struct {
+	name     string
+	isAccess bool
+	stage    networkStage
+	ipv4Net  *net.IPNet
+	ipv6Net  *net.IPNet
+}
+
+type imageSnapshot struct {
+	id             string
+	pullStage      pullStage
+	provisionStage provisionStage
+}
+
+// takeSnapshot copies the state, so that it can be dumped from another
+// goroutine.
+func takeSnapshot(suiteRun *testSuiteRun, state *suiteState) *stateSnapshot {
+	snapshot := &stateSnapshot{}
+	for _, run := range suiteRun.testRuns {
+		r := runSnapshot{testID: run.testID, stage: state.runStage[run.testID]}
+		if res, ok := state.runResults[run.testID]; ok {
+			r.status = res.status
+		}
+		if resources, ok := state.runResources[run.testID]; ok {
+			resources.ids = slices.Clone(resources.ids)
+			resources.networkNames = slices.Clone(resources.networkNames)
+			r.resources = &resources
+		}
+		snapshot.runs = append(snapshot.runs, r)
+	}
+
+	for id := range state.freeIDs {
+		snapshot.freeIDs = append(snapshot.freeIDs, id)
+	}
+	sort.Ints(snapshot.freeIDs)
+
+	for name, ns := range state.networks {
+		snapshot.networks = append(snapshot.networks, networkSnapshot{
+			name:     name,
+			isAccess: ns.isAccess,
+			stage:    ns.stage,
+			ipv4Net:  ns.ipv4Net,
+			ipv6Net:  ns.ipv6Net,
+		})
+	}
+	sort.Slice(snapshot.networks, func(i, j int) bool {
+		return snapshot.networks[i].name < snapshot.networks[j].name
+	})
+
+	for _, v := range suiteRun.vmSpec.VMs {
+		snapshot.images = append(snapshot.images, imageSnapshot{
+			id:             v.ID(),
+			pullStage:      state.pullStage[v.BaseImage],
+			provisionStage: state.provisionStage[v.ID()],
+		})
+	}
+
+	for _, err := range state.errors {
+		snapshot.errors = append(snapshot.errors, err.Error())
+	}
+	return snapshot
+}
+
+// formatStateDump describes the state of the scheduler and the running
+// commands for debugging a test suite run that appears to hang.
+func formatStateDump(suiteRun *testSuiteRun, snapshot *stateSnapshot, processes []childProcess, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "State at %s, running for %v\n", now.Format(time.RFC3339), now.Sub(suiteRun.startTime).Round(time.Second))
+
+	fmt.Fprintln(&b, "\nRuns:")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  RUN\tSTAGE\tSTATUS\tIDS\tNETWORKS\tELAPSED")
+	for _, run := range snapshot.runs {
+		ids := ""
+		networks := ""
+		elapsed := ""
+		if run.resources != nil {
+			ids = strings.Trim(fmt.Sprint(run.resources.ids), "[]")
+			networks = strings.Join(run.resources.networkNames, ",")
+			elapsed = now.Sub(run.resources.start).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n", run.testID, run.stage, run.status, ids, networks, elapsed)
+	}
+	w.Flush()
+
+	fmt.Fprintf(&b, "\nVM IDs: %d of %d in use, free %v\n", suiteRun.nrVMs-len(snapshot.freeIDs), suiteRun.nrVMs, snapshot.freeIDs)
+
+	fmt.Fprintln(&b, "\nNetworks:")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, ns := range snapshot.networks {
+		kind := "extra"
+		if ns.isAccess {
+			kind = "access"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", ns.name, kind, ns.stage, ipNetString(ns.ipv4Net), ipNetString(ns.ipv6Net))
+	}
+	w.Flush()
+
+	fmt.Fprintln(&b, "\nImages:")
+	w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, image := range snapshot.images {
+		fmt.Fprintf(w, "  %s\tpull %s\tprovision %s\n", image.id, image.pullStage, image.provisionStage)
+	}
+	w.Flush()
+
+	fmt.Fprintln(&b, "\nProcesses:")
+	for _, p := range processes {
+		fmt.Fprintf(&b, "  PID %d running for %v: %s\n", p.pid, now.Sub(p.start).Round(time.Second), strings.Join(p.argv, " "))
+	}
+
+	fmt.Fprintf(&b, "\nErrors: %d\n", len(snapshot.errors))
+	for _, err := range snapshot.errors {
+		fmt.Fprintf(&b, "  %s\n", err)
+	}
+	return b.String()
+}
+
+func ipNetString(n *net.IPNet) string {
+	if n == nil {
+		return "-"
+	}
+	return n.String()
+}
+
+// stateDumper dumps the state on SIGUSR1. The scheduler publishes a snapshot
+// of its state after each change, so that the state can also be dumped while
+// the test suite run is torn down.
+type stateDumper struct {
+	mu       sync.Mutex
+	snapshot *stateSnapshot
+}
+
+func newStateDumper() *stateDumper {
+	return &stateDumper{snapshot: &stateSnapshot{}}
+}
+
+// publish replaces the snapshot that is dumped.
+func (d *stateDumper) publish(snapshot *stateSnapshot) {
+	d.mu.Lock()
+	d.snapshot = snapshot
+	d.mu.Unlock()
+}
+
+func (d *stateDumper) latest() *stateSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot
+}
+
+// watchSignals dumps the latest snapshot on SIGUSR1 until the command exits.
+func (d *stateDumper) watchSignals(suiteRun *testSuiteRun) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGUSR1)
+	for range signals {
+		dumpState(suiteRun, d.latest())
+	}
+}
+
+// dumpState writes the state to the log and to a file in the output
+// directory.
+func dumpState(suiteRun *testSuiteRun, snapshot *stateSnapshot) {
+	now := time.Now()
+	dump := secrets.mask(formatStateDump(suiteRun, snapshot, runningChildProcesses(), now))
+
+	for _, line := range strings.Split(strings.TrimRight(dump, "\n"), "\n") {
+		log.Infof("DUMP: %s", line)
+	}
+
+	if suiteRun.outDir == "" {
+		return
+	}
+
+	filename := filepath.Join(suiteRun.outDir, fmt.Sprintf("state-dump-%s.txt", now.Format(stateDumpTimeFormat)))
+	if err := os.WriteFile(filename, []byte(dump), 0644); err != nil {
+		log.Warnf("Failed to write state dump: %v", err)
+		return
+	}
+	log.Infof("DUMP: Written to %s", filename)
+}
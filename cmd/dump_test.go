@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatStateDump(t *testing.T) {
+	_, baseNet, err := net.ParseCIDR("10.224.0.0/24")
+	require.NoError(t, err)
+
+	vm0 := vm{BaseImage: "b0"}
+	config := &testConfig{testName: "t", vmSpec: &vmSpecification{VMs: []vm{vm0}}, repeats: 1}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	suiteRun := &testSuiteRun{
+		vmSpec: config.vmSpec,
+		testRuns: []testRun{
+			{testName: "t", testID: "t-1-default-0", vms: []vm{vm0}, variant: variant{Name: "default"}, config: config},
+			{testName: "t", testID: "t-1-default-1", vms: []vm{vm0}, variant: variant{Name: "default"}, config: config},
+		},
+		startVM:    5,
+		nrVMs:      2,
+		firstV4Net: baseNet,
+		startTime:  now.Add(-time.Hour),
+		outDir:     t.TempDir(),
+	}
+	state := initializeState(suiteRun)
+	state.runStage["t-1-default-0"] = runExec
+	state.runResources["t-1-default-0"] = runResources{ids: []int{5}, networkNames: []string{"access"}, start: now.Add(-90 * time.Second)}
+	delete(state.freeIDs, 5)
+
+	processes := []childProcess{{pid: 1234, argv: []string{"virter", "vm", "exec", "t-1-default-0"}, start: now.Add(-time.Minute)}}
+	snapshot := takeSnapshot(suiteRun, state)
+	dump := formatStateDump(suiteRun, snapshot, processes, now)
+
+	assert.Contains(t, dump, "running for 1h0m0s")
+	assert.Contains(t, dump, "t-1-default-0  Exec")
+	assert.Contains(t, dump, "1m30s")
+	assert.Contains(t, dump, "VM IDs: 1 of 2 in use, free [6]")
+	assert.Contains(t, dump, "PID 1234 running for 1m0s: virter vm exec t-1-default-0")
+	assert.Contains(t, dump, "Errors: 0")
+
+	dumpState(suiteRun, snapshot)
+	files, err := filepath.Glob(filepath.Join(suiteRun.outDir, "state-dump-*.txt"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	content, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "t-1-default-0")
+}
+
+func TestStateDumperSnapshot(t *testing.T) {
+	config := &testConfig{testName: "t", vmSpec: &vmSpecification{}, repeats: 1}
+	suiteRun := &testSuiteRun{
+		vmSpec:   config.vmSpec,
+		testRuns: []testRun{{testName: "t", testID: "t-1-default-0", config: config}},
+		startVM:  5,
+		nrVMs:    1,
+	}
+	state := initializeState(suiteRun)
+
+	dumper := newStateDumper()
+	suiteRun.dumps = dumper
+	publishState(suiteRun, state)
+
+	// Later changes of the state are only visible once they are published
+	state.runStage["t-1-default-0"] = runDone
+	state.runResults["t-1-default-0"] = testResult{status: StatusSuccess}
+	require.Len(t, dumper.latest().runs, 1)
+	assert.Equal(t, runNew, dumper.latest().runs[0].stage)
+
+	persistState(suiteRun, state)
+	assert.Equal(t, runDone, dumper.latest().runs[0].stage)
+	assert.Equal(t, StatusSuccess, dumper.latest().runs[0].status)
+}
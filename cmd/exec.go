@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -18,6 +20,46 @@ type execResultMeta struct {
 	ExitCode int `json:"exit_code"`
 }
 
+// childProcess is a running command started by cmdRunTerm.
+type childProcess struct {
+	pid   int
+	argv  []string
+	start time.Time
+}
+
+// childProcesses keeps track of the running commands for state dumps.
+var childProcesses = struct {
+	sync.Mutex
+	byPID map[int]childProcess
+}{byPID: make(map[int]childProcess)}
+
+func addChildProcess(cmd *exec.Cmd) {
+	childProcesses.Lock()
+	defer childProcesses.Unlock()
+	childProcesses.byPID[cmd.Process.Pid] = childProcess{pid: cmd.Process.Pid, argv: cmd.Args, start: time.Now()}
+}
+
+func removeChildProcess(cmd *exec.Cmd) {
+	childProcesses.Lock()
+	defer childProcesses.Unlock()
+	delete(childProcesses.byPID, cmd.Process.Pid)
+}
+
+// runningChildProcesses returns the running commands ordered by start time.
+func runningChildProcesses() []childProcess {
+	childProcesses.Lock()
+	defer childProcesses.Unlock()
+
+	processes := make([]childProcess, 0, len(childProcesses.byPID))
+	for _, p := range childProcesses.byPID {
+		processes = append(processes, p)
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].start.Before(processes[j].start)
+	})
+	return processes
+}
+
 // cmdStderrTerm runs a Cmd, collecting stderr and terminating gracefully
 func cmdStderrTerm(ctx context.Context, logger log.FieldLogger, stderrPath string, metaPath string, cmd *exec.Cmd) error {
 	var out bytes.Buffer
@@ -25,10 +67,11 @@ func cmdStderrTerm(ctx context.Context, logger log.FieldLogger, stderrPath strin
 	cmd.Stderr = &out
 
 	err := cmdRunTerm(ctx, logger, cmd)
+	stderr := secrets.maskBytes(out.Bytes())
 
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
-		exitErr.Stderr = out.Bytes()
+		exitErr.Stderr = stderr
 		meta.ExitCode = exitErr.ExitCode()
 	}
 
@@ -39,7 +82,7 @@ func cmdStderrTerm(ctx context.Context, logger log.FieldLogger, stderrPath strin
 		return mkdirErr
 	}
 
-	if stderrWriteErr := os.WriteFile(stderrPath, out.Bytes(), 0644); stderrWriteErr != nil {
+	if stderrWriteErr := os.WriteFile(stderrPath, stderr, 0644); stderrWriteErr != nil {
 		if err != nil {
 			logger.Errorf("Failed to write stderr; suppressing original error: %v\n", err)
 		}
@@ -77,6 +120,8 @@ func cmdRunTerm(ctx context.Context, logger log.FieldLogger, cmd *exec.Cmd) erro
 	if err != nil {
 		return err
 	}
+	addChildProcess(cmd)
+	defer removeChildProcess(cmd)
 
 	complete := make(chan struct{})
 	finished := make(chan struct{})
@@ -101,9 +146,12 @@ func handleTermination(ctx context.Context, logger log.FieldLogger, cmd *exec.Cm
 		logger.Warnln("TERMINATING: Send SIGTERM")
 		cmd.Process.Signal(unix.SIGTERM)
 		select {
-		case <-time.After(30 * time.Second):
+		case <-time.After(termination.gracePeriod):
 			logger.Errorln("TERMINATING: Send SIGKILL")
 			cmd.Process.Kill()
+		case <-termination.force:
+			logger.Errorln("TERMINATING: Interrupted again, send SIGKILL")
+			cmd.Process.Kill()
 		case <-complete:
 		}
 	case <-complete:
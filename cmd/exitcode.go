@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OutcomeClass groups the results of test runs for the exit code.
+type OutcomeClass string
+
+const (
+	OutcomeFailure  OutcomeClass = "failure"  // the test failed or timed out
+	OutcomeError    OutcomeClass = "error"    // the test could not be run, for instance because a VM did not start
+	OutcomeSkipped  OutcomeClass = "skipped"  // the run was not started before the soft timeout
+	OutcomeCanceled OutcomeClass = "canceled" // vmshed was interrupted or the run was canceled by a control command
+)
+
+// outcomePrecedence lists the outcome classes in the order in which they
+// determine the exit code when several occur.
+var outcomePrecedence = []OutcomeClass{OutcomeFailure, OutcomeError, OutcomeCanceled, OutcomeSkipped}
+
+// defaultExitCodes keeps the exit code 1 for any unsuccessful run. Distinct
+// codes per class are chosen with --exit-code.
+func defaultExitCodes() map[OutcomeClass]int {
+	return map[OutcomeClass]int{
+		OutcomeFailure:  1,
+		OutcomeError:    1,
+		OutcomeSkipped:  0,
+		OutcomeCanceled: 1,
+	}
+}
+
+// parseExitCodes overrides the default exit codes with the values given as
+// class=code.
+func parseExitCodes(overrides map[string]int) (map[OutcomeClass]int, error) {
+	codes := defaultExitCodes()
+	for name, code := range overrides {
+		class := OutcomeClass(name)
+		if _, ok := codes[class]; !ok {
+			return nil, fmt.Errorf("unknown outcome class %q, should be one out of %s", name, outcomeClassNames())
+		}
+		if code < 0 || code > 125 {
+			return nil, fmt.Errorf("exit code %d for %s out of range 0-125", code, name)
+		}
+		codes[class] = code
+	}
+	return codes, nil
+}
+
+func outcomeClassNames() string {
+	names := []string{}
+	for _, class := range outcomePrecedence {
+		names = append(names, fmt.Sprintf("%q", class))
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// resultOutcome returns the outcome class of a test run result. Returns an
+// empty class for successful runs, expected failures, disabled tests and runs
+// canceled because the suite stopped after a failure.
+func resultOutcome(res testResult) OutcomeClass {
+	switch res.status {
+	case StatusFailed, StatusFailedTimeout:
+		return OutcomeFailure
+	case StatusCanceled:
+		if res.userCanceled {
+			return OutcomeCanceled
+		}
+		// the failure that stopped the suite determines the exit code
+		return ""
+	case StatusSuccess, StatusXFail, StatusXPass:
+		return ""
+	}
+	if res.err == nil {
+		// disabled test or run not started because of a control command
+		return ""
+	}
+	// errors and runs that were skipped because the scheduler stopped
+	return OutcomeError
+}
+
+// exitCode determines the exit code from the outcome classes that occurred
+// and returns it together with the class that determined it. Failures are
+// ignored if the success rate in percent reaches the threshold.
+func exitCode(codes map[OutcomeClass]int, outcomes map[OutcomeClass]int, successRate float64, successThreshold float64) (int, OutcomeClass) {
+	for _, class := range outcomePrecedence {
+		if outcomes[class] == 0 || codes[class] == 0 {
+			continue
+		}
+		if class == OutcomeFailure && successRate >= successThreshold {
+			continue
+		}
+		return codes[class], class
+	}
+	return 0, ""
+}
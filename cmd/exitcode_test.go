@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultOutcome(t *testing.T) {
+	assert.Equal(t, OutcomeClass(""), resultOutcome(testResult{status: StatusSuccess}))
+	assert.Equal(t, OutcomeClass(""), resultOutcome(testResult{status: StatusXFail}))
+	assert.Equal(t, OutcomeClass(""), resultOutcome(testResult{status: StatusSkipped, note: "disabled: x"}))
+	assert.Equal(t, OutcomeFailure, resultOutcome(testResult{status: StatusFailedTimeout, err: errors.New("timeout")}))
+	assert.Equal(t, OutcomeError, resultOutcome(testResult{status: StatusError, err: errors.New("no VM")}))
+	assert.Equal(t, OutcomeError, resultOutcome(testResult{status: StatusSkipped, err: errors.New("skipped")}))
+	assert.Equal(t, OutcomeCanceled, resultOutcome(testResult{status: StatusCanceled, err: errors.New("canceled"), userCanceled: true}))
+	assert.Equal(t, OutcomeClass(""), resultOutcome(testResult{status: StatusCanceled, err: errors.New("canceled")}),
+		"runs canceled by the scheduler after a failure are not counted")
+}
+
+func TestExitCode(t *testing.T) {
+	defaults, err := parseExitCodes(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, defaults[OutcomeError], "the old exit codes are kept by default")
+	assert.Equal(t, 0, defaults[OutcomeSkipped])
+
+	codes, err := parseExitCodes(map[string]int{"error": 2, "canceled": 4})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		outcomes  map[OutcomeClass]int
+		rate      float64
+		threshold float64
+		code      int
+	}{
+		{"success", map[OutcomeClass]int{}, 100, 100, 0},
+		{"failure", map[OutcomeClass]int{OutcomeFailure: 1}, 90, 100, 1},
+		{"failure below threshold", map[OutcomeClass]int{OutcomeFailure: 2}, 80, 90, 1},
+		{"failure above threshold", map[OutcomeClass]int{OutcomeFailure: 1}, 90, 90, 0},
+		{"failure and error", map[OutcomeClass]int{OutcomeFailure: 1, OutcomeError: 1}, 50, 100, 1},
+		{"error above threshold", map[OutcomeClass]int{OutcomeFailure: 1, OutcomeError: 1}, 90, 80, 2},
+		{"failure before canceled", map[OutcomeClass]int{OutcomeCanceled: 1, OutcomeFailure: 1}, 0, 100, 1},
+		{"canceled", map[OutcomeClass]int{OutcomeCanceled: 1, OutcomeSkipped: 1}, 100, 100, 4},
+		{"skipped ignored", map[OutcomeClass]int{OutcomeSkipped: 3}, 100, 100, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _ := exitCode(codes, tc.outcomes, tc.rate, tc.threshold)
+			assert.Equal(t, tc.code, code)
+		})
+	}
+
+	_, err = parseExitCodes(map[string]int{"flaky": 1})
+	assert.Error(t, err)
+	_, err = parseExitCodes(map[string]int{"failure": 300})
+	assert.Error(t, err)
+}
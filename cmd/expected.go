@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+)
+
+// expectedFailure marks the runs of a test that are known to fail. The runs
+// are still carried out, but their failure does not fail the test suite run.
+type expectedFailure struct {
+	Test      string    `toml:"test"`
+	Variant   string    `toml:"variant"`    // only runs of this variant, if empty all
+	BaseImage string    `toml:"base_image"` // only runs using this VM, if empty all
+	Reason    string    `toml:"reason"`
+	Expires   time.Time `toml:"expires"` // date after which the entry is ignored, if zero never
+}
+
+// quarantineFile is the format of the file given with --quarantine.
+type quarantineFile struct {
+	ExpectedFailures []expectedFailure `toml:"expected_failures"`
+}
+
+func loadQuarantineFile(filename string) ([]expectedFailure, error) {
+	var quarantine quarantineFile
+	if _, err := toml.DecodeFile(filename, &quarantine); err != nil {
+		return nil, fmt.Errorf("failed to load quarantine file: %w", err)
+	}
+	return quarantine.ExpectedFailures, nil
+}
+
+func (e expectedFailure) expired(now time.Time) bool {
+	// The entry applies for the whole day of the expiry date
+	return !e.Expires.IsZero() && !now.Before(e.Expires.AddDate(0, 0, 1))
+}
+
+func (e expectedFailure) matches(run *testRun) bool {
+	if e.Test != run.testName {
+		return false
+	}
+	if e.Variant != "" && e.Variant != run.variant.Name {
+		return false
+	}
+	if e.BaseImage == "" {
+		return true
+	}
+	for _, v := range run.vms {
+		if v.BaseImage == e.BaseImage || v.ID() == e.BaseImage {
+			return true
+		}
+	}
+	return false
+}
+
+// activeExpectedFailures validates the entries and returns those that have
+// not expired.
+func activeExpectedFailures(entries []expectedFailure, now time.Time) ([]expectedFailure, error) {
+	active := []expectedFailure{}
+	for i, e := range entries {
+		if e.Test == "" {
+			return nil, fmt.Errorf("expected failure %d: no test given", i)
+		}
+		if e.expired(now) {
+			log.Warnf("Ignoring expected failure of %s which expired on %s", e.Test, e.Expires.Format(time.DateOnly))
+			continue
+		}
+		active = append(active, e)
+	}
+	return active, nil
+}
+
+func findExpectedFailure(entries []expectedFailure, run *testRun) *expectedFailure {
+	for i := range entries {
+		if entries[i].matches(run) {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// applyExpectedFailure turns the failure of a run that is expected to fail
+// into XFAIL and its success into XPASS. Errors running the test and canceled
+// runs are not affected.
+func applyExpectedFailure(logger *log.Logger, entries []expectedFailure, run *testRun, res *testResult) {
+	expected := findExpectedFailure(entries, run)
+	if expected == nil {
+		return
+	}
+
+	switch res.status {
+	case StatusFailed, StatusFailedTimeout:
+		logger.Infof("EXPECTED FAILURE: %s: %v", expected.Reason, res.err)
+		res.note = fmt.Sprintf("expected failure: %s: %v", expected.Reason, res.err)
+		res.status = StatusXFail
+		res.err = nil
+	case StatusSuccess:
+		logger.Warnf("UNEXPECTED SUCCESS: expected failure: %s", expected.Reason)
+		res.note = fmt.Sprintf("unexpected success, expected failure: %s", expected.Reason)
+		res.status = StatusXPass
+	}
+}
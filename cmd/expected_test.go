@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadQuarantineFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "quarantine.toml")
+	require.NoError(t, os.WriteFile(filename, []byte(`
+[[expected_failures]]
+test = "flaky"
+base_image = "alma-9"
+reason = "issue 123"
+expires = 2024-03-01
+`), 0644))
+
+	entries, err := loadQuarantineFile(filename)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "flaky", entries[0].Test)
+	assert.Equal(t, "alma-9", entries[0].BaseImage)
+	assert.Equal(t, "issue 123", entries[0].Reason)
+
+	dayBefore := time.Date(2024, 3, 1, 23, 0, 0, 0, entries[0].Expires.Location())
+	dayAfter := time.Date(2024, 3, 2, 0, 0, 0, 0, entries[0].Expires.Location())
+	assert.False(t, entries[0].expired(dayBefore))
+	assert.True(t, entries[0].expired(dayAfter))
+
+	active, err := activeExpectedFailures(entries, dayAfter)
+	require.NoError(t, err)
+	assert.Empty(t, active)
+
+	_, err = activeExpectedFailures([]expectedFailure{{Reason: "no test"}}, dayBefore)
+	assert.Error(t, err)
+}
+
+func TestApplyExpectedFailure(t *testing.T) {
+	entries := []expectedFailure{
+		{Test: "t1", Variant: "v2", Reason: "variant"},
+		{Test: "t2", BaseImage: "b", Reason: "image"},
+	}
+	run := func(name string, variantName string, images ...string) *testRun {
+		r := &testRun{testName: name, variant: variant{Name: variantName}}
+		for _, image := range images {
+			r.vms = append(r.vms, vm{BaseImage: image})
+		}
+		return r
+	}
+
+	cases := []struct {
+		name   string
+		run    *testRun
+		status TestStatus
+		expect TestStatus
+	}{
+		{"failure", run("t1", "v2", "a"), StatusFailed, StatusXFail},
+		{"timeout", run("t2", "v1", "a", "b"), StatusFailedTimeout, StatusXFail},
+		{"success", run("t2", "v1", "b"), StatusSuccess, StatusXPass},
+		{"error", run("t2", "v1", "b"), StatusError, StatusError},
+		{"other variant", run("t1", "v1", "a"), StatusFailed, StatusFailed},
+		{"other image", run("t2", "v1", "a"), StatusFailed, StatusFailed},
+		{"other test", run("t3", "v2", "b"), StatusFailed, StatusFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := testResult{status: tc.status}
+			if tc.status != StatusSuccess {
+				res.err = errors.New("exit status 1")
+			}
+
+			applyExpectedFailure(log.New(), entries, tc.run, &res)
+			assert.Equal(t, tc.expect, res.status)
+			switch tc.expect {
+			case StatusXFail:
+				assert.NoError(t, res.err)
+				assert.Contains(t, res.note, "exit status 1")
+			case StatusXPass:
+				assert.NoError(t, res.err)
+				assert.Contains(t, res.note, "unexpected success")
+			default:
+				assert.Error(t, res.err)
+				assert.Empty(t, res.note)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lineWriter writes complete lines to out, each with the prefix and with
+// secrets masked. A partial line is kept until it is completed or the writer
+// is closed.
+type lineWriter struct {
+	out    io.Writer
+	prefix string
+	// terminate ends a partial line with a newline when the writer is closed
+	terminate bool
+	partial   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) writeLine(line []byte) error {
+	// Write the line in one go, so that it is not mixed with other output
+	_, err := w.out.Write(append([]byte(w.prefix), secrets.maskBytes(line)...))
+	return err
+}
+
+// Close writes the remaining partial line.
+func (w *lineWriter) Close() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	if w.terminate {
+		w.partial = append(w.partial, '\n')
+	}
+	err := w.writeLine(w.partial)
+	w.partial = nil
+	return err
+}
+
+// errorRecordingWriter records the first write error instead of returning it,
+// so that the other outputs of an io.MultiWriter still receive the data.
+type errorRecordingWriter struct {
+	w   io.Writer
+	err *error
+}
+
+func (w *errorRecordingWriter) Write(p []byte) (int, error) {
+	if *w.err != nil {
+		return len(p), nil
+	}
+	if _, err := w.w.Write(p); err != nil {
+		*w.err = err
+	}
+	return len(p), nil
+}
+
+// following returns whether the output of a run should be shown on the
+// console. Runs are selected by test name or test ID.
+func following(suiteRun *testSuiteRun, run *testRun) bool {
+	return containsString(suiteRun.follow, run.testName) || containsString(suiteRun.follow, run.testID)
+}
+
+// warnUnknownFollow warns about --follow values that match no run.
+func warnUnknownFollow(suiteRun *testSuiteRun) {
+	for _, name := range suiteRun.follow {
+		found := false
+		for _, run := range suiteRun.testRuns {
+			if run.testName == name || run.testID == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Warnf("Not following %s: no run of this test is selected", name)
+		}
+	}
+}
+
+// createTestLog creates test.log in the output directory of the run, so that
+// the test output can be written as it arrives.
+func createTestLog(run *testRun) (*os.File, error) {
+	if err := os.MkdirAll(run.outDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(run.outDir, "test.log"))
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineWriter(t *testing.T) {
+	var out strings.Builder
+	w := &lineWriter{out: &out, prefix: "[t-1] ", terminate: true}
+
+	for _, chunk := range []string{"first", " line\nsecond line\nth", "ird"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "[t-1] first line\n[t-1] second line\n", out.String())
+
+	require.NoError(t, w.Close())
+	assert.Equal(t, "[t-1] first line\n[t-1] second line\n[t-1] third\n", out.String())
+}
+
+func TestLineWriterMasksSecrets(t *testing.T) {
+	saved := secrets
+	t.Cleanup(func() { secrets = saved })
+	secrets = newSecretMasker([]string{"token123"})
+
+	var out strings.Builder
+	w := &lineWriter{out: &out}
+	for _, chunk := range []string{"using tok", "en123\nend with token", "123"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, "using ***\nend with ***", out.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestErrorRecordingWriter(t *testing.T) {
+	var err error
+	var out strings.Builder
+	w := io.MultiWriter(&errorRecordingWriter{w: failingWriter{}, err: &err}, &out)
+
+	_, writeErr := w.Write([]byte("output\n"))
+	require.NoError(t, writeErr)
+	assert.EqualError(t, err, "disk full")
+	assert.Equal(t, "output\n", out.String())
+}
+
+func TestFollowing(t *testing.T) {
+	suiteRun := &testSuiteRun{follow: []string{"t1", "t2-1-default-1"}}
+	assert.True(t, following(suiteRun, &testRun{testName: "t1", testID: "t1-1-default-0"}))
+	assert.True(t, following(suiteRun, &testRun{testName: "t2", testID: "t2-1-default-1"}))
+	assert.False(t, following(suiteRun, &testRun{testName: "t2", testID: "t2-1-default-0"}))
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const historyFileTimeFormat = "20060102T150405.000000000Z"
+
+func historyCommand() *cobra.Command {
+	var historyDir string
+
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Accumulate results of test suite runs and report on them",
+		Long: `Accumulate results of test suite runs and report on them.
+
+The history is a directory with one JSON Lines file for each ingested
+test suite run in the format of results.json.`,
+	}
+	historyCmd.PersistentFlags().StringVar(&historyDir, "dir", "vmshed-history", "Directory containing the history")
+
+	ingestCmd := &cobra.Command{
+		Use:   "ingest RESULTS...",
+		Short: "Add the results.json files of test suite runs to the history",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, filename := range args {
+				added, err := ingestResults(historyDir, filename)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if added {
+					log.Infof("Added %s to history", filename)
+				} else {
+					log.Infof("Skipped %s, already in history or empty", filename)
+				}
+			}
+		},
+	}
+
+	var last int
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report pass rates, flakiness and durations of the tests",
+		Long: `Report pass rates, flakiness and durations of the tests.
+
+The statistics are computed for each test and for each combination of test
+and base image over the last test suite runs. The flakiness is the fraction
+of consecutive runs with a different outcome.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			results, err := loadHistory(historyDir, last)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			printHistoryReport(os.Stdout, results)
+		},
+	}
+	reportCmd.Flags().IntVar(&last, "last", 30, "Number of most recent test suite runs to include, 0 means all")
+
+	historyCmd.AddCommand(ingestCmd, reportCmd)
+	return historyCmd
+}
+
+// ingestResults copies a results.json file into the history. Returns false if
+// the test suite run is already in the history or has no results.
+func ingestResults(historyDir string, filename string) (bool, error) {
+	results, err := loadResultsJSON(filename)
+	if err != nil {
+		return false, err
+	}
+	if len(results) == 0 {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return false, err
+	}
+
+	// All runs of a test suite run share the start time
+	name := results[0].Time.UTC().Format(historyFileTimeFormat) + ".jsonl"
+	dest := filepath.Join(historyDir, name)
+	if _, err := os.Stat(dest); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+
+	if err := writeFileAtomic(dest, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to add %s to history: %w", filename, err)
+	}
+	return true, nil
+}
+
+// loadHistory returns the results of the last test suite runs in the history
+// in chronological order.
+func loadHistory(historyDir string, last int) ([]resultData, error) {
+	filenames, err := filepath.Glob(filepath.Join(historyDir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The file names sort chronologically
+	sort.Strings(filenames)
+	if last > 0 && len(filenames) > last {
+		filenames = filenames[len(filenames)-last:]
+	}
+
+	results := []resultData{}
+	for _, filename := range filenames {
+		fileResults, err := loadResultsJSON(filename)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, fileResults...)
+	}
+	return results, nil
+}
+
+type historyStats struct {
+	runs      int
+	successes int
+	// Runs with status ERROR, which are not counted as failures
+	errors    int
+	flips     int
+	last      *bool
+	durations []time.Duration
+}
+
+func (s *historyStats) add(data resultData) {
+	s.runs++
+	if data.Status == string(StatusError) {
+		// the test could not be run, which says nothing about the test
+		s.errors++
+		return
+	}
+
+	success := data.Score > 0
+	if s.last != nil && *s.last != success {
+		s.flips++
+	}
+	s.last = &success
+
+	if success {
+		s.successes++
+	}
+	s.durations = append(s.durations, time.Duration(data.DurationNS))
+}
+
+// passRate returns the fraction of successful runs among the runs without
+// errors.
+func (s *historyStats) passRate() float64 {
+	if s.runs == s.errors {
+		return 0
+	}
+	return float64(s.successes) / float64(s.runs-s.errors)
+}
+
+func (s *historyStats) errorRate() float64 {
+	return float64(s.errors) / float64(s.runs)
+}
+
+// flakiness returns the fraction of consecutive runs without errors with
+// different outcomes.
+func (s *historyStats) flakiness() float64 {
+	if s.runs-s.errors < 2 {
+		return 0
+	}
+	return float64(s.flips) / float64(s.runs-s.errors-1)
+}
+
+// percentile returns the duration below which p percent of the durations lie,
+// using the nearest-rank method.
+func (s *historyStats) percentile(p int) time.Duration {
+	if len(s.durations) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, s.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// historyStatistics computes statistics for each test and for each
+// combination of test and base image.
+func historyStatistics(results []resultData) (map[string]*historyStats, map[string]*historyStats) {
+	byTest := map[string]*historyStats{}
+	byImage := map[string]*historyStats{}
+
+	sorted := append([]resultData{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	for _, data := range sorted {
+		switch TestStatus(data.Status) {
+		case StatusSkipped, StatusCanceled, StatusXFail:
+			// disabled tests and canceled runs were not run to the
+			// end and expected failures are not test outcomes
+			continue
+		}
+
+		if byTest[data.Name] == nil {
+			byTest[data.Name] = &historyStats{}
+		}
+		byTest[data.Name].add(data)
+
+		seen := map[string]bool{}
+		for _, image := range data.BaseImages {
+			if seen[image] {
+				continue
+			}
+			seen[image] = true
+
+			key := data.Name + " " + image
+			if byImage[key] == nil {
+				byImage[key] = &historyStats{}
+			}
+			byImage[key].add(data)
+		}
+	}
+	return byTest, byImage
+}
+
+func printHistoryReport(w io.Writer, results []resultData) {
+	byTest, byImage := historyStatistics(results)
+	printHistoryStats(w, "Test", byTest)
+	fmt.Fprintln(w)
+	printHistoryStats(w, "Test and base image", byImage)
+}
+
+func printHistoryStats(w io.Writer, title string, stats map[string]*historyStats) {
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%-50s %6s %9s %9s %9s %9s %9s %9s\n", title, "Runs", "Pass", "Error", "Flaky", "P50", "P90", "P99")
+	fmt.Fprintln(w, strings.Repeat("-", 118))
+	for _, key := range keys {
+		s := stats[key]
+		fmt.Fprintf(w, "%-50s %6d %8.1f%% %8.1f%% %8.1f%% %9v %9v %9v\n", key, s.runs,
+			100*s.passRate(), 100*s.errorRate(), 100*s.flakiness(),
+			s.percentile(50).Round(time.Second),
+			s.percentile(90).Round(time.Second),
+			s.percentile(99).Round(time.Second))
+	}
+}
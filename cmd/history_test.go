@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeResultsJSON(t *testing.T, filename string, results []resultData) {
+	t.Helper()
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, data := range results {
+		require.NoError(t, enc.Encode(&data))
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	historyDir := filepath.Join(dir, "history")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	outcomes := []bool{true, false, true, true}
+	for i, success := range outcomes {
+		score := 0
+		if success {
+			score = 1
+		}
+		suiteTime := start.Add(time.Duration(i) * 24 * time.Hour)
+		filename := filepath.Join(dir, "results.json")
+		writeResultsJSON(t, filename, []resultData{
+			{Time: suiteTime, Name: "t1", BaseImages: []string{"a", "a"}, Score: score, DurationNS: int64(time.Duration(i+1) * time.Minute)},
+			{Time: suiteTime, Name: "t2", BaseImages: []string{"b"}, Score: 1, DurationNS: int64(time.Minute)},
+		})
+
+		added, err := ingestResults(historyDir, filename)
+		require.NoError(t, err)
+		assert.True(t, added)
+
+		added, err = ingestResults(historyDir, filename)
+		require.NoError(t, err)
+		assert.False(t, added, "the same test suite run must only be ingested once")
+	}
+
+	results, err := loadHistory(historyDir, 3)
+	require.NoError(t, err)
+	assert.Len(t, results, 6)
+	assert.Equal(t, start.Add(24*time.Hour), results[0].Time)
+
+	byTest, byImage := historyStatistics(results)
+	t1 := byTest["t1"]
+	assert.Equal(t, 3, t1.runs)
+	assert.InDelta(t, 2.0/3, t1.passRate(), 1e-9)
+	assert.InDelta(t, 0.5, t1.flakiness(), 1e-9)
+	assert.Equal(t, 3*time.Minute, t1.percentile(50))
+	assert.Equal(t, 4*time.Minute, t1.percentile(90))
+
+	assert.Equal(t, 3, byImage["t1 a"].runs, "each run counts once per base image")
+	assert.Equal(t, 0.0, byImage["t2 b"].flakiness())
+	assert.Equal(t, 1.0, byImage["t2 b"].passRate())
+}
+
+func TestHistoryStatuses(t *testing.T) {
+	result := func(status TestStatus, duration time.Duration) resultData {
+		return resultData{Name: "t", BaseImages: []string{"a"}, Status: string(status),
+			Score: statusScore(status), DurationNS: int64(duration)}
+	}
+
+	byTest, _ := historyStatistics([]resultData{
+		result(StatusSuccess, time.Minute),
+		result(StatusCanceled, time.Second),
+		result(StatusError, time.Second),
+		result(StatusXFail, time.Minute),
+		result(StatusSuccess, 3*time.Minute),
+		result(StatusFailed, 2*time.Minute),
+		result(StatusSkipped, 0),
+	})
+
+	s := byTest["t"]
+	assert.Equal(t, 4, s.runs, "canceled, skipped and expected failures are not counted")
+	assert.Equal(t, 1, s.errors)
+	assert.InDelta(t, 2.0/3, s.passRate(), 1e-9, "errors are not failures")
+	assert.InDelta(t, 0.25, s.errorRate(), 1e-9)
+	assert.InDelta(t, 0.5, s.flakiness(), 1e-9, "errors do not interrupt a series of successes")
+	assert.Equal(t, 2*time.Minute, s.percentile(50), "durations of errors are not counted")
+
+	onlyErrors, _ := historyStatistics([]resultData{result(StatusError, time.Second)})
+	assert.Equal(t, 0.0, onlyErrors["t"].passRate())
+	assert.Equal(t, time.Duration(0), onlyErrors["t"].percentile(50))
+}
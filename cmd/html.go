@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Matches the console logs written by virter, such as "lbtest-vm-2.log" or
+// "ci1-lbtest-vm-2.log"
+var consoleLogRegexp = regexp.MustCompile(`^(.+-)?lbtest-vm-\d+\.log$`)
+
+type htmlReport struct {
+	StartTime time.Time
+	Seed      int64
+	Counts    []htmlCount
+	Runs      []htmlRun
+	Images    []string
+	Matrix    []htmlMatrixRow
+	Timeline  bool
+}
+
+type htmlCount struct {
+	Status string
+	Count  int
+}
+
+type htmlRun struct {
+	resultData
+	Duration time.Duration
+	Links    []htmlLink
+	// Position of the run in the timeline in percent
+	Offset float64
+	Width  float64
+}
+
+type htmlLink struct {
+	Name string
+	Href string
+}
+
+type htmlMatrixRow struct {
+	Test  string
+	Cells []htmlMatrixCell
+}
+
+type htmlMatrixCell struct {
+	Success int
+	Total   int
+}
+
+func (c htmlMatrixCell) Class() string {
+	switch {
+	case c.Total == 0:
+		return "none"
+	case c.Success == c.Total:
+		return "ok"
+	case c.Success == 0:
+		return "fail"
+	default:
+		return "mixed"
+	}
+}
+
+func statusClass(status string) string {
+	switch TestStatus(status) {
+	case StatusSuccess, StatusXPass:
+		return "ok"
+	case StatusFailed, StatusFailedTimeout, StatusError:
+		return "fail"
+	default:
+		return "other"
+	}
+}
+
+// saveHTMLReport writes a self-contained HTML report of the results to the
+// output directory.
+func saveHTMLReport(suiteRun testSuiteRun, startTime time.Time, results map[string]testResult) error {
+	filename := filepath.Join(suiteRun.outDir, "report.html")
+	log.Infof("Saving HTML report to %s", filename)
+
+	report := makeHTMLReport(suiteRun.outDir, resultRecords(suiteRun, startTime, results))
+	report.StartTime = startTime
+	report.Seed = suiteRun.seed
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML report: %w", err)
+	}
+	defer f.Close()
+
+	if err := htmlReportTemplate.Execute(f, report); err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	return f.Sync()
+}
+
+func makeHTMLReport(outDir string, records []resultData) *htmlReport {
+	report := &htmlReport{}
+
+	counts := map[string]int{}
+	imageSet := map[string]bool{}
+	for _, data := range records {
+		counts[data.Status]++
+		for _, image := range data.BaseImages {
+			imageSet[image] = true
+		}
+		report.Runs = append(report.Runs, htmlRun{
+			resultData: data,
+			Duration:   time.Duration(data.DurationNS).Round(time.Second),
+			Links:      runLinks(outDir, data.ID),
+		})
+	}
+
+	for status, count := range counts {
+		report.Counts = append(report.Counts, htmlCount{Status: status, Count: count})
+	}
+	sort.Slice(report.Counts, func(i, j int) bool {
+		return report.Counts[i].Status < report.Counts[j].Status
+	})
+
+	for image := range imageSet {
+		report.Images = append(report.Images, image)
+	}
+	sort.Strings(report.Images)
+
+	report.Matrix = resultMatrix(records, report.Images)
+	report.Timeline = setTimelinePositions(report.Runs)
+	return report
+}
+
+// resultMatrix counts the successful and total runs for each combination of
+// test and base image.
+func resultMatrix(records []resultData, images []string) []htmlMatrixRow {
+	cells := map[string]map[string]*htmlMatrixCell{}
+	for _, data := range records {
+		if cells[data.Name] == nil {
+			cells[data.Name] = map[string]*htmlMatrixCell{}
+			for _, image := range images {
+				cells[data.Name][image] = &htmlMatrixCell{}
+			}
+		}
+
+		seen := map[string]bool{}
+		for _, image := range data.BaseImages {
+			if seen[image] {
+				continue
+			}
+			seen[image] = true
+
+			cell := cells[data.Name][image]
+			cell.Total++
+			if statusScore(TestStatus(data.Status)) > 0 {
+				cell.Success++
+			}
+		}
+	}
+
+	tests := make([]string, 0, len(cells))
+	for test := range cells {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+
+	matrix := []htmlMatrixRow{}
+	for _, test := range tests {
+		row := htmlMatrixRow{Test: test}
+		for _, image := range images {
+			row.Cells = append(row.Cells, *cells[test][image])
+		}
+		matrix = append(matrix, row)
+	}
+	return matrix
+}
+
+// setTimelinePositions sets the position of each run in the timeline. Returns
+// false if no run has a start time.
+func setTimelinePositions(runs []htmlRun) bool {
+	var first, last time.Time
+	for _, run := range runs {
+		if run.Start.IsZero() {
+			continue
+		}
+		end := run.Start.Add(time.Duration(run.DurationNS))
+		if first.IsZero() || run.Start.Before(first) {
+			first = run.Start
+		}
+		if end.After(last) {
+			last = end
+		}
+	}
+
+	if first.IsZero() {
+		return false
+	}
+
+	span := last.Sub(first)
+	if span <= 0 {
+		span = time.Second
+	}
+
+	for i := range runs {
+		if runs[i].Start.IsZero() {
+			continue
+		}
+		runs[i].Offset = 100 * float64(runs[i].Start.Sub(first)) / float64(span)
+		runs[i].Width = max(0.5, 100*float64(runs[i].DurationNS)/float64(span))
+	}
+	return true
+}
+
+// runLinks returns links relative to the output directory to the logs and
+// artifacts of a run that exist.
+func runLinks(outDir string, testID string) []htmlLink {
+	runDir := filepath.Join("log", testID)
+	links := []htmlLink{}
+	for _, name := range []string{"test.log", "report.log"} {
+		if _, err := os.Stat(filepath.Join(outDir, runDir, name)); err == nil {
+			links = append(links, htmlLink{Name: name, Href: filepath.ToSlash(filepath.Join(runDir, name))})
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(outDir, runDir))
+	if err != nil {
+		return links
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			links = append(links, htmlLink{Name: entry.Name() + "/", Href: filepath.ToSlash(filepath.Join(runDir, entry.Name())) + "/"})
+		} else if consoleLogRegexp.MatchString(entry.Name()) {
+			links = append(links, htmlLink{Name: strings.TrimSuffix(entry.Name(), ".log") + " console", Href: filepath.ToSlash(filepath.Join(runDir, entry.Name()))})
+		}
+	}
+	return links
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"statusClass": statusClass,
+	"join":        strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>vmshed report</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+table.sortable th { cursor: pointer; background: #eee; }
+.ok { background: #c8f0c8; }
+.fail { background: #f4c0c0; }
+.mixed { background: #f8e8a8; }
+.other { background: #e0e0e0; }
+.none { background: #fff; }
+.timeline { position: relative; height: 1.2em; min-width: 40em; background: #f6f6f6; }
+.timeline div { position: absolute; top: 0; height: 100%; }
+</style>
+</head>
+<body>
+<h1>vmshed report</h1>
+<p>Started {{.StartTime.Format "2006-01-02 15:04:05 MST"}}, seed {{.Seed}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Status</th><th>Runs</th></tr>
+{{- range .Counts}}
+<tr><td class="{{statusClass .Status}}">{{.Status}}</td><td>{{.Count}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Runs</h2>
+<table class="sortable">
+<thead>
+<tr><th>Run</th><th>Test</th><th>Status</th><th>Variant</th><th>VMs</th><th>Base images</th><th>Duration</th><th>Logs</th></tr>
+</thead>
+<tbody>
+{{- range .Runs}}
+<tr>
+<td>{{.ID}}</td>
+<td>{{.Name}}</td>
+<td class="{{statusClass .Status}}">{{.Status}}</td>
+<td>{{.Variant}}</td>
+<td>{{.VMCount}}</td>
+<td>{{join .BaseImages ", "}}</td>
+<td data-sort="{{.DurationNS}}">{{.Duration}}</td>
+<td>{{range .Links}}<a href="{{.Href}}">{{.Name}}</a> {{end}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+
+<h2>Test &times; base image</h2>
+<table>
+<tr><th>Test</th>{{range .Images}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Matrix}}
+<tr><td>{{.Test}}</td>{{range .Cells}}<td class="{{.Class}}">{{if .Total}}{{.Success}}/{{.Total}}{{end}}</td>{{end}}</tr>
+{{- end}}
+</table>
+
+{{- if .Timeline}}
+
+<h2>Timeline</h2>
+<table>
+{{- range .Runs}}
+<tr><td>{{.ID}}</td><td class="timeline">{{if not .Start.IsZero}}<div class="{{statusClass .Status}}" style="left: {{printf "%.2f" .Offset}}%; width: {{printf "%.2f" .Width}}%" title="{{.Start.Format "15:04:05"}} {{.Duration}}"></div>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<script>
+document.querySelectorAll("table.sortable th").forEach(function(th, col) {
+	th.addEventListener("click", function() {
+		var body = th.closest("table").tBodies[0];
+		var asc = th.dataset.order !== "asc";
+		th.dataset.order = asc ? "asc" : "desc";
+		var key = function(row) {
+			var cell = row.cells[col];
+			return cell.dataset.sort !== undefined ? cell.dataset.sort : cell.textContent;
+		};
+		var rows = Array.from(body.rows);
+		rows.sort(function(a, b) {
+			var x = key(a), y = key(b);
+			var c = (x !== "" && y !== "" && !isNaN(x) && !isNaN(y)) ? Number(x) - Number(y) : x.localeCompare(y);
+			return asc ? c : -c;
+		});
+		rows.forEach(function(row) { body.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeHTMLReport(t *testing.T) {
+	outDir := t.TempDir()
+	runDir := filepath.Join(outDir, "log", "t1-2-v-0")
+	require.NoError(t, os.MkdirAll(filepath.Join(runDir, "lbtest-vm-2"), 0755))
+	for _, name := range []string{"test.log", "lbtest-vm-2.log", "vm_run_lbtest-vm-2.log"} {
+		require.NoError(t, os.WriteFile(filepath.Join(runDir, name), nil, 0644))
+	}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []resultData{
+		{ID: "t1-2-v-0", Name: "t1", BaseImages: []string{"a", "b"}, Status: "SUCCESS", DurationNS: int64(time.Minute), Start: start},
+		{ID: "t1-2-v-1", Name: "t1", BaseImages: []string{"b", "b"}, Status: "FAILED", DurationNS: int64(time.Minute), Start: start.Add(time.Minute)},
+		{ID: "t2-1-v-0", Name: "t2", BaseImages: []string{"a"}, Status: "SUCCESS"},
+	}
+
+	report := makeHTMLReport(outDir, records)
+	assert.Equal(t, []htmlCount{{Status: "FAILED", Count: 1}, {Status: "SUCCESS", Count: 2}}, report.Counts)
+	assert.Equal(t, []string{"a", "b"}, report.Images)
+	assert.Equal(t, []htmlMatrixRow{
+		{Test: "t1", Cells: []htmlMatrixCell{{Success: 1, Total: 1}, {Success: 1, Total: 2}}},
+		{Test: "t2", Cells: []htmlMatrixCell{{Success: 1, Total: 1}, {}}},
+	}, report.Matrix)
+
+	assert.Equal(t, []htmlLink{
+		{Name: "test.log", Href: "log/t1-2-v-0/test.log"},
+		{Name: "lbtest-vm-2/", Href: "log/t1-2-v-0/lbtest-vm-2/"},
+		{Name: "lbtest-vm-2 console", Href: "log/t1-2-v-0/lbtest-vm-2.log"},
+	}, report.Runs[0].Links)
+
+	assert.True(t, report.Timeline)
+	assert.Equal(t, 0.0, report.Runs[0].Offset)
+	assert.Equal(t, 50.0, report.Runs[1].Offset)
+	assert.Equal(t, 50.0, report.Runs[1].Width)
+
+	var html strings.Builder
+	require.NoError(t, htmlReportTemplate.Execute(&html, report))
+	assert.Contains(t, html.String(), "t1-2-v-1")
+}
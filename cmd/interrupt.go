@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const defaultTerminationGracePeriod = 30 * time.Second
+
+// terminationControl determines how long commands are given to exit after
+// SIGTERM before they are killed.
+type terminationControl struct {
+	gracePeriod time.Duration
+	force       chan struct{} // closed when commands should be killed immediately
+	forceOnce   sync.Once
+}
+
+func newTerminationControl(gracePeriod time.Duration) *terminationControl {
+	return &terminationControl{gracePeriod: gracePeriod, force: make(chan struct{})}
+}
+
+// forceKill makes commands that are being terminated be killed without
+// waiting for the rest of the grace period.
+func (t *terminationControl) forceKill() {
+	t.forceOnce.Do(func() { close(t.force) })
+}
+
+// termination applies to all commands run by cmdRunTerm.
+var termination = newTerminationControl(defaultTerminationGracePeriod)
+
+var (
+	errInterrupted       = errors.New("interrupted by signal")
+	errCanceledByControl = errors.New("canceled by control command")
+)
+
+// canceledByUser returns whether the context was canceled by a signal or a
+// control command, as opposed to the scheduler stopping after a failure.
+func canceledByUser(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	return errors.Is(cause, errInterrupted) || errors.Is(cause, errCanceledByControl)
+}
+
+// interruptContext returns a context that is canceled on the first SIGINT or
+// SIGTERM, so that running tests are terminated and no new ones are started.
+// The second signal kills the commands that are being terminated. Further
+// signals are ignored, so that the VMs, networks and images are still removed.
+func interruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		interrupts := 0
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				interrupts++
+				switch interrupts {
+				case 1:
+					log.Warnf("STATUS: Received %v, terminating running tests within %v; interrupt again to kill them immediately", sig, termination.gracePeriod)
+					cancel(errInterrupted)
+				case 2:
+					log.Warnf("STATUS: Received %v again, killing running commands; cleanup continues", sig)
+					termination.forceKill()
+				default:
+					log.Warnf("STATUS: Received %v, waiting for cleanup to finish", sig)
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// cleanupReport collects the VMs, networks and images that could not be
+// removed. A nil report ignores failures.
+type cleanupReport struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *cleanupReport) add(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *cleanupReport) failures() []error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errs...)
+}
+
+// logSummary lists what could not be cleaned up.
+func (r *cleanupReport) logSummary() {
+	failures := r.failures()
+	if len(failures) == 0 {
+		return
+	}
+
+	log.Warnf("CLEANUP: %d objects could not be removed:", len(failures))
+	for _, err := range failures {
+		log.Warnf("CLEANUP: %v", err)
+	}
+	log.Info("Use \"vmshed cleanup\" with the same flags to remove them")
+}
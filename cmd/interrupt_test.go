@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminationForceKill(t *testing.T) {
+	saved := termination
+	t.Cleanup(func() { termination = saved })
+	termination = newTerminationControl(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The command ignores SIGTERM, so only SIGKILL stops it
+	cmd := exec.Command("sh", "-c", "trap '' TERM; sleep 60 & wait")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cmdRunTerm(ctx, log.New(), cmd)
+	}()
+
+	// Give the shell time to set up the trap
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-errCh:
+		t.Fatal("command exited before the grace period")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	termination.forceKill()
+	termination.forceKill()
+
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("command was not killed")
+	}
+}
+
+func TestCleanupReport(t *testing.T) {
+	var nilReport *cleanupReport
+	nilReport.add(errors.New("ignored"))
+	assert.Empty(t, nilReport.failures())
+
+	report := &cleanupReport{}
+	report.add(errors.New("remove VM vm-2: exit status 1"))
+	report.add(errors.New("remove network net-0: exit status 1"))
+	require.Len(t, report.failures(), 2)
+	assert.EqualError(t, report.failures()[1], "remove network net-0: exit status 1")
+}
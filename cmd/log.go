@@ -67,10 +67,10 @@ func TestLogger(testID string, out io.Writer) *log.Logger {
 	logger := log.New()
 	logger.Out = out
 	logger.Level = log.DebugLevel
-	logger.Formatter = &log.TextFormatter{
+	logger.Formatter = &maskingFormatter{&log.TextFormatter{
 		DisableQuote:    true,
 		TimestampFormat: "15:04:05.000",
-	}
+	}}
 
 	logger.AddHook(&StandardLoggerHook{testID: testID})
 	return logger
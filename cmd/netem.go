@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	networkEventPartition = "partition"
+	networkEventHeal      = "heal"
+)
+
+// networkEvent changes the network conditions of some VMs while a test is
+// running.
+type networkEvent struct {
+	After    duration `toml:"after"`    // time after the VMs have started
+	Action   string   `toml:"action"`   // partition or heal
+	VMs      []int    `toml:"vms"`      // indexes of the affected VMs in the test run
+	Networks []int    `toml:"networks"` // indexes of the affected extra networks, defaults to all
+}
+
+// hasNetem returns whether network emulation is configured for the network.
+func (n virterNet) hasNetem() bool {
+	return len(netemArgs(n)) > 0
+}
+
+// netemArgs returns the tc netem parameters for the network.
+func netemArgs(network virterNet) []string {
+	args := []string{}
+	if network.Latency > 0 {
+		args = append(args, "delay", tcTime(time.Duration(network.Latency)))
+		if network.Jitter > 0 {
+			args = append(args, tcTime(time.Duration(network.Jitter)))
+		}
+	}
+	if network.Loss > 0 {
+		args = append(args, "loss", tcPercent(network.Loss))
+	}
+	if network.Reorder > 0 {
+		args = append(args, "reorder", tcPercent(network.Reorder))
+	}
+	if network.Bandwidth != "" {
+		args = append(args, "rate", network.Bandwidth)
+	}
+	return args
+}
+
+func tcTime(d time.Duration) string {
+	return fmt.Sprintf("%dus", d.Microseconds())
+}
+
+func tcPercent(p float64) string {
+	return fmt.Sprintf("%g%%", p)
+}
+
+func validateNetworkEmulation(config *testConfig) error {
+	for i, network := range config.networks {
+		if (network.Jitter > 0 || network.Reorder > 0) && network.Latency <= 0 {
+			return fmt.Errorf("network %d: jitter and reorder require latency", i)
+		}
+		if network.Loss < 0 || network.Loss > 100 || network.Reorder < 0 || network.Reorder > 100 {
+			return fmt.Errorf("network %d: loss and reorder must be percentages between 0 and 100", i)
+		}
+	}
+
+	minVMs := minVMCount(config.test)
+	for i, event := range config.test.NetworkEvents {
+		if event.Action != networkEventPartition && event.Action != networkEventHeal {
+			return fmt.Errorf("network event %d: action should be one out of %q %q", i, networkEventPartition, networkEventHeal)
+		}
+		if len(config.networks) == 0 {
+			return fmt.Errorf("network event %d: the test has no extra networks", i)
+		}
+		if len(event.VMs) == 0 {
+			return fmt.Errorf("network event %d: no VMs given", i)
+		}
+		for _, vmIndex := range event.VMs {
+			if vmIndex < 0 || vmIndex >= minVMs {
+				return fmt.Errorf("network event %d: VM index %d out of range for %d VMs", i, vmIndex, minVMs)
+			}
+		}
+		for _, networkIndex := range event.Networks {
+			if networkIndex < 0 || networkIndex >= len(config.networks) {
+				return fmt.Errorf("network event %d: network index %d out of range for %d networks", i, networkIndex, len(config.networks))
+			}
+		}
+	}
+	return nil
+}
+
+// networkEmulation applies the netem settings of the extra networks to the tap
+// devices of the VMs of a test run and carries out the network events.
+type networkEmulation struct {
+	logger *log.Logger
+	run    *testRun
+	// Indexed by VM index and then by network index, only for the
+	// networks the VM is attached to
+	taps   []map[int]string
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// startNetworkEmulation configures the network emulation for the started VMs
+// and schedules the network events. Returns nil if the run does not use
+// network emulation.
+func startNetworkEmulation(ctx context.Context, logger *log.Logger, run *testRun, testnodes []vmInstance) (*networkEmulation, error) {
+	needed := len(run.networkEvents) > 0
+	for _, network := range run.networks {
+		if network.hasNetem() {
+			needed = true
+		}
+	}
+	if !needed {
+		return nil, nil
+	}
+
+	taps := make([]map[int]string, len(testnodes))
+	for i, vm := range testnodes {
+		interfaces, err := vmInterfaces(ctx, logger, run.outDir, vm.vmName())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list interfaces of %s: %w", vm.vmName(), err)
+		}
+
+		taps[i] = map[int]string{}
+		for j, network := range run.networks {
+			if !network.attached(i) {
+				continue
+			}
+
+			networkName := vm.networkNames[j+1]
+			tap, ok := interfaces[networkName]
+			if !ok {
+				return nil, fmt.Errorf("VM %s has no interface on network %s", vm.vmName(), networkName)
+			}
+			taps[i][j] = tap
+		}
+	}
+
+	e := &networkEmulation{
+		logger: logger,
+		run:    run,
+		taps:   taps,
+		done:   make(chan struct{}),
+	}
+
+	for i := range testnodes {
+		for j, network := range run.networks {
+			if !network.hasNetem() {
+				continue
+			}
+			if err := e.setNetem(ctx, i, j, netemArgs(network)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	eventCtx, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+	go e.runEvents(eventCtx, time.Now())
+	return e, nil
+}
+
+// stop cancels the outstanding network events and returns the error of a
+// failed event, if any.
+func (e *networkEmulation) stop() error {
+	if e == nil {
+		return nil
+	}
+
+	e.cancel()
+	<-e.done
+	return e.err
+}
+
+func (e *networkEmulation) runEvents(ctx context.Context, start time.Time) {
+	defer close(e.done)
+
+	events := append([]networkEvent{}, e.run.networkEvents...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].After < events[j].After
+	})
+
+	for _, event := range events {
+		timer := time.NewTimer(time.Until(start.Add(time.Duration(event.After))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		e.logger.Infof("NETEM: %s VMs %v after %v", event.Action, event.VMs, time.Duration(event.After))
+		if err := e.apply(ctx, event); err != nil {
+			e.logger.Errorf("NETEM: %s failed: %v", event.Action, err)
+			e.err = err
+			return
+		}
+	}
+}
+
+func (e *networkEmulation) apply(ctx context.Context, event networkEvent) error {
+	networkIndexes := event.Networks
+	if len(networkIndexes) == 0 {
+		for j := range e.run.networks {
+			networkIndexes = append(networkIndexes, j)
+		}
+	}
+
+	for _, i := range event.VMs {
+		for _, j := range networkIndexes {
+			var err error
+			if event.Action == networkEventHeal {
+				err = e.heal(ctx, i, j)
+			} else {
+				err = e.partition(ctx, i, j)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// partition drops all packets sent to and from VM i on network j. Packets sent
+// to the VM leave the host through the root qdisc of the tap device, packets
+// sent by the VM arrive at its ingress qdisc.
+func (e *networkEmulation) partition(ctx context.Context, i int, j int) error {
+	if err := e.setNetem(ctx, i, j, []string{"loss", "100%"}); err != nil {
+		return err
+	}
+
+	tap, ok := e.taps[i][j]
+	if !ok {
+		return nil
+	}
+	if err := e.tc(ctx, tap, "qdisc", "replace", "dev", tap, "handle", "ffff:", "ingress"); err != nil {
+		return err
+	}
+	return e.tc(ctx, tap, "filter", "replace", "dev", tap, "parent", "ffff:", "pref", "1", "handle", "1", "matchall", "action", "drop")
+}
+
+// heal restores the configured network emulation of VM i on network j.
+func (e *networkEmulation) heal(ctx context.Context, i int, j int) error {
+	if err := e.setNetem(ctx, i, j, netemArgs(e.run.networks[j])); err != nil {
+		return err
+	}
+
+	tap, ok := e.taps[i][j]
+	if !ok {
+		return nil
+	}
+	if err := e.tc(ctx, tap, "qdisc", "del", "dev", tap, "ingress"); err != nil && !isNoQdiscError(err) {
+		return err
+	}
+	return nil
+}
+
+// setNetem replaces the netem qdisc of the tap device of VM i on network j.
+// Without arguments the qdisc is removed. Nothing is done if the VM is not
+// attached to the network.
+func (e *networkEmulation) setNetem(ctx context.Context, i int, j int, args []string) error {
+	tap, ok := e.taps[i][j]
+	if !ok {
+		return nil
+	}
+
+	if len(args) == 0 {
+		err := e.tc(ctx, tap, "qdisc", "del", "dev", tap, "root")
+		if err != nil && !isNoQdiscError(err) {
+			return err
+		}
+		return nil
+	}
+	return e.tc(ctx, tap, append([]string{"qdisc", "replace", "dev", tap, "root", "netem"}, args...)...)
+}
+
+// tc runs tc with the given arguments for the tap device.
+func (e *networkEmulation) tc(ctx context.Context, tap string, args ...string) error {
+	argv := append([]string{"tc"}, args...)
+	stderrPath := filepath.Join(e.run.outDir, fmt.Sprintf("tc_%s.log", tap))
+	e.logger.Debugf("EXECUTING: %s", argv)
+	return cmdStderrTerm(ctx, e.logger, stderrPath, "", exec.Command(argv[0], argv[1:]...))
+}
+
+// noQdiscMessages are printed by tc when deleting a qdisc that does not
+// exist. The messages differ between versions of tc and the kernel.
+var noQdiscMessages = []string{
+	"Cannot find specified qdisc",
+	"Cannot delete qdisc with handle of zero",
+	"No such file or directory",
+}
+
+// isNoQdiscError returns whether tc failed because there was no qdisc to
+// delete.
+func isNoQdiscError(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, message := range noQdiscMessages {
+		if bytes.Contains(exitErr.Stderr, []byte(message)) {
+			return true
+		}
+	}
+	return false
+}
+
+// libvirtURI returns the URI of the libvirt instance which virter uses.
+// Without it, virsh connects to the session instance for non-root users.
+func libvirtURI() string {
+	if uri := os.Getenv("LIBVIRT_DEFAULT_URI"); uri != "" {
+		return uri
+	}
+	return "qemu:///system"
+}
+
+// vmInterfaces returns the names of the tap devices of a VM indexed by
+// network name.
+func vmInterfaces(ctx context.Context, logger *log.Logger, outDir string, vmName string) (map[string]string, error) {
+	var out bytes.Buffer
+	argv := []string{"virsh", "-c", libvirtURI(), "domiflist", vmName}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = &out
+
+	stderrPath := filepath.Join(outDir, fmt.Sprintf("virsh_domiflist_%s.log", vmName))
+	logger.Debugf("EXECUTING: %s", argv)
+	if err := cmdStderrTerm(ctx, logger, stderrPath, "", cmd); err != nil {
+		return nil, err
+	}
+	return parseDomIfList(out.String()), nil
+}
+
+// parseDomIfList parses the output of "virsh domiflist" into a map from
+// network name to interface name.
+func parseDomIfList(output string) map[string]string {
+	interfaces := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "network" {
+			continue
+		}
+		interfaces[fields[2]] = fields[0]
+	}
+	return interfaces
+}
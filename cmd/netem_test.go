@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetemArgs(t *testing.T) {
+	assert.Empty(t, netemArgs(virterNet{ForwardMode: "nat"}))
+
+	network := virterNet{
+		Latency:   duration(100 * time.Millisecond),
+		Jitter:    duration(10 * time.Millisecond),
+		Loss:      0.5,
+		Reorder:   25,
+		Bandwidth: "10mbit",
+	}
+	assert.Equal(t,
+		[]string{"delay", "100000us", "10000us", "loss", "0.5%", "reorder", "25%", "rate", "10mbit"},
+		netemArgs(network))
+}
+
+func TestParseDomIfList(t *testing.T) {
+	output := ` Interface   Type      Source            Model    MAC
+-----------------------------------------------------------------------
+ vnet0       network   vmshed-0-access   virtio   52:54:00:00:00:02
+ vnet1       network   vmshed-1-extra    virtio   52:54:00:8a:1f:3c
+
+`
+	assert.Equal(t, map[string]string{
+		"vmshed-0-access": "vnet0",
+		"vmshed-1-extra":  "vnet1",
+	}, parseDomIfList(output))
+}
+
+func TestValidateNetworkEmulation(t *testing.T) {
+	extra := virterNet{Latency: duration(time.Millisecond)}
+
+	testCases := []struct {
+		name    string
+		config  testConfig
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			config: testConfig{
+				test: test{
+					VMCount:       []int{2, 3},
+					NetworkEvents: []networkEvent{{Action: "partition", VMs: []int{1}, Networks: []int{0}}},
+				},
+				networks: []virterNet{extra},
+			},
+		},
+		{
+			name: "jitter-without-latency",
+			config: testConfig{
+				networks: []virterNet{{Jitter: duration(time.Millisecond)}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown-action",
+			config: testConfig{
+				test: test{
+					VMCount:       []int{2},
+					NetworkEvents: []networkEvent{{Action: "explode", VMs: []int{0}}},
+				},
+				networks: []virterNet{extra},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vm-out-of-range",
+			config: testConfig{
+				test: test{
+					VMCount:       []int{2, 3},
+					NetworkEvents: []networkEvent{{Action: "partition", VMs: []int{2}}},
+				},
+				networks: []virterNet{extra},
+			},
+			wantErr: true,
+		},
+		{
+			name: "no-extra-networks",
+			config: testConfig{
+				test: test{
+					VMCount:       []int{2},
+					NetworkEvents: []networkEvent{{Action: "heal", VMs: []int{0}}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateNetworkEmulation(&tc.config)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIsNoQdiscError(t *testing.T) {
+	run := func(stderr string) error {
+		cmd := exec.Command("sh", "-c", "echo \"$0\" >&2; exit 2", stderr)
+		return cmdStderrTerm(context.Background(), log.StandardLogger(), filepath.Join(t.TempDir(), "tc.log"), "", cmd)
+	}
+
+	assert.True(t, isNoQdiscError(run("Error: Cannot find specified qdisc on specified device.")))
+	assert.True(t, isNoQdiscError(run("Error: Cannot delete qdisc with handle of zero.")))
+	assert.True(t, isNoQdiscError(run("RTNETLINK answers: No such file or directory")))
+	assert.False(t, isNoQdiscError(run("RTNETLINK answers: Operation not permitted")))
+	assert.False(t, isNoQdiscError(errors.New("No such file or directory")))
+}
+
+func TestLibvirtURI(t *testing.T) {
+	t.Setenv("LIBVIRT_DEFAULT_URI", "")
+	assert.Equal(t, "qemu:///system", libvirtURI())
+
+	t.Setenv("LIBVIRT_DEFAULT_URI", "qemu+ssh://host/system")
+	assert.Equal(t, "qemu+ssh://host/system", libvirtURI())
+}
@@ -21,12 +21,15 @@ func addNetwork(ctx context.Context, outDir string, networkName string, network
 
 	argv := []string{"virter", "network", "add", networkName}
 	if network.DHCP {
-		if ipV4Net == nil {
+		if ipV4Net == nil && ipV6Net == nil {
 			panic("cannot add network with DHCP without an IPNet")
 		}
-		gatewayAddress := cidr.Inc(ipV4Net.IP)
-		networkCidr := net.IPNet{IP: gatewayAddress, Mask: ipV4Net.Mask}
-		argv = append(argv, "--network-cidr", networkCidr.String(), "--dhcp")
+		if ipV4Net != nil {
+			gatewayAddress := cidr.Inc(ipV4Net.IP)
+			networkCidr := net.IPNet{IP: gatewayAddress, Mask: ipV4Net.Mask}
+			argv = append(argv, "--network-cidr", networkCidr.String())
+		}
+		argv = append(argv, "--dhcp")
 		if ipV6Net != nil {
 			gatewayAddress := cidr.Inc(ipV6Net.IP)
 			networkCidr := net.IPNet{IP: gatewayAddress, Mask: ipV6Net.Mask}
@@ -75,11 +78,134 @@ func removeNetwork(outDir string, networkName string) error {
 	return nil
 }
 
-func accessNetwork(ipv6 bool) virterNet {
+// hasIPv4 returns whether the network has IPv4 addresses.
+func (n virterNet) hasIPv4() bool {
+	return n.IPv4 == nil || *n.IPv4
+}
+
+func validateNetworkAddresses(networks []virterNet) error {
+	for i, network := range networks {
+		if network.DHCP && !network.hasIPv4() && !network.IPv6 {
+			return fmt.Errorf("network %d: ipv4 = false requires ipv6 = true", i)
+		}
+		if network.IPv4Prefix != 0 && (network.IPv4Prefix < 8 || network.IPv4Prefix > 30) {
+			return fmt.Errorf("network %d: ipv4_prefix must be between 8 and 30", i)
+		}
+		if network.IPv6Prefix != 0 && (network.IPv6Prefix < 8 || network.IPv6Prefix > 126) {
+			return fmt.Errorf("network %d: ipv6_prefix must be between 8 and 126", i)
+		}
+	}
+	return nil
+}
+
+// attached returns whether the VM with the given index is attached to the
+// network.
+func (n virterNet) attached(vmIndex int) bool {
+	if len(n.VMs) == 0 {
+		return true
+	}
+
+	for _, i := range n.VMs {
+		if i == vmIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// nicArgument returns the value of the --nic option for "virter vm run" to
+// attach the VM with the given index to the network.
+func nicArgument(networkName string, network virterNet, vmIndex int) string {
+	nic := fmt.Sprintf("type=network,source=%s", networkName)
+	if vmIndex < len(network.MACs) && network.MACs[vmIndex] != "" {
+		nic += ",mac=" + network.MACs[vmIndex]
+	}
+	if network.MTU > 0 {
+		nic += fmt.Sprintf(",mtu=%d", network.MTU)
+	}
+	return nic
+}
+
+// minVMCount returns the smallest number of VMs of the runs of a test. VM
+// indexes in the configuration of the test must refer to a VM in every run,
+// so they must be below this number.
+func minVMCount(t test) int {
+	minVMs := 0
+	for i, vmCount := range t.VMCount {
+		if i == 0 || vmCount < minVMs {
+			minVMs = vmCount
+		}
+	}
+	return minVMs
+}
+
+func validateNICs(config *testConfig) error {
+	minVMs := minVMCount(config.test)
+	for i, network := range config.networks {
+		for _, vmIndex := range network.VMs {
+			if vmIndex < 0 || vmIndex >= minVMs {
+				return fmt.Errorf("network %d: VM index %d out of range for %d VMs", i, vmIndex, minVMs)
+			}
+		}
+		for _, mac := range network.MACs {
+			if mac == "" {
+				continue
+			}
+			if _, err := net.ParseMAC(mac); err != nil {
+				return fmt.Errorf("network %d: %w", i, err)
+			}
+		}
+		if network.MTU < 0 {
+			return fmt.Errorf("network %d: MTU must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// accessNetwork returns the network through which the VMs of the variant are
+// accessed.
+func accessNetwork(v variant) virterNet {
 	return virterNet{
 		Domain:      "test",
 		ForwardMode: "nat",
 		DHCP:        true,
-		IPv6:        ipv6,
+		IPv4:        v.IPv4,
+		IPv6:        v.IPv6,
+	}
+}
+
+// validateMaxNetworks checks that every run can be started with at most
+// maxNetworks networks. Access networks are never removed, so a run may have
+// to share the limit with all access networks.
+func validateMaxNetworks(suiteRun *testSuiteRun, maxNetworks int) error {
+	if maxNetworks == 0 {
+		return nil
 	}
+
+	accessNetworks := []virterNet{}
+	addAccessNetwork := func(network virterNet) {
+		if !networkNeeded(accessNetworks, network) {
+			accessNetworks = append(accessNetworks, network)
+		}
+	}
+	if suiteRun.vmSpec.ProvisionFile != "" {
+		addAccessNetwork(accessNetwork(variant{}))
+	}
+	for _, run := range suiteRun.testRuns {
+		if run.disabled == "" {
+			addAccessNetwork(accessNetwork(run.variant))
+		}
+	}
+
+	for _, run := range suiteRun.testRuns {
+		if run.disabled != "" {
+			continue
+		}
+		needed := len(accessNetworks) + len(run.networks)
+		if needed > maxNetworks {
+			return fmt.Errorf("test run %s needs %d networks including %d access networks, but --max-networks is %d",
+				run.testID, needed, len(accessNetworks), maxNetworks)
+		}
+	}
+	return nil
 }
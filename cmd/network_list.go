@@ -1,15 +1,22 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 
 	"github.com/apparentlymart/go-cidr/cidr"
 )
 
 type networkList struct {
+	// The IP of the current network is the first address which has not
+	// been reserved yet, the mask is the default size of new networks.
 	currentV4 *net.IPNet
 	currentV6 *net.IPNet
-	freeNets  map[string]bool
+	// Networks must end before these addresses, nil if unlimited
+	limitV4  net.IP
+	limitV6  net.IP
+	freeNets map[string]bool
 }
 
 func NewNetworkList(currentV4, currentV6 *net.IPNet) *networkList {
@@ -20,36 +27,74 @@ func NewNetworkList(currentV4, currentV6 *net.IPNet) *networkList {
 	}
 }
 
-func (n *networkList) ReserveNext(ipv6 bool) *net.IPNet {
+// Limit restricts the networks to addresses before the given networks. nil
+// means unlimited.
+func (n *networkList) Limit(limitV4, limitV6 *net.IPNet) {
+	n.limitV4 = nil
+	if limitV4 != nil {
+		n.limitV4 = limitV4.IP
+	}
+	n.limitV6 = nil
+	if limitV6 != nil {
+		n.limitV6 = limitV6.IP
+	}
+}
+
+// ReserveNext reserves a network with the given prefix length. A prefix of 0
+// means the size of the first network.
+func (n *networkList) ReserveNext(ipv6 bool, prefix int) (*net.IPNet, error) {
+	current := n.currentV4
+	limit := n.limitV4
+	if ipv6 {
+		current = n.currentV6
+		limit = n.limitV6
+	}
+
+	defaultPrefix, bits := current.Mask.Size()
+	if prefix == 0 {
+		prefix = defaultPrefix
+	}
+
 	for k, v := range n.freeNets {
 		ipNet := mustParse(k)
 		isIPv6 := ipNet.IP.To4() == nil
+		size, _ := ipNet.Mask.Size()
 
-		if v && isIPv6 == ipv6 {
+		if v && isIPv6 == ipv6 && size == prefix {
 			n.freeNets[k] = false
-			return ipNet
+			return ipNet, nil
 		}
 	}
 
-	candidate := n.currentV4
-	if ipv6 {
-		candidate = n.currentV6
+	mask := net.CIDRMask(prefix, bits)
+	candidate := &net.IPNet{IP: current.IP.Mask(mask), Mask: mask}
+	if !candidate.IP.Equal(current.IP) {
+		// The start of the network containing the first unreserved
+		// address has already been reserved
+		var exceed bool
+		candidate, exceed = cidr.NextSubnet(candidate, prefix)
+		if exceed {
+			return nil, fmt.Errorf("available subnets exhausted")
+		}
 	}
 
-	prefix, _ := candidate.Mask.Size()
 	next, exceed := cidr.NextSubnet(candidate, prefix)
 	if exceed {
-		panic("available subnets exhausted")
+		return nil, fmt.Errorf("available subnets exhausted")
+	}
+	if limit != nil && bytes.Compare(next.IP.To16(), limit.To16()) > 0 {
+		return nil, fmt.Errorf("no free subnet with prefix /%d before %s", prefix, limit)
 	}
 
 	n.freeNets[candidate.String()] = false
+	nextFree := &net.IPNet{IP: next.IP, Mask: current.Mask}
 	if ipv6 {
-		n.currentV6 = next
+		n.currentV6 = nextFree
 	} else {
-		n.currentV4 = next
+		n.currentV4 = nextFree
 	}
 
-	return candidate
+	return candidate, nil
 }
 
 func (n *networkList) Free(ipNet *net.IPNet) {
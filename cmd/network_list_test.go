@@ -5,19 +5,29 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/LINBIT/vmshed/cmd"
 )
 
+func reserve(t *testing.T, list interface {
+	ReserveNext(bool, int) (*net.IPNet, error)
+}, ipv6 bool, prefix int) *net.IPNet {
+	t.Helper()
+	ipNet, err := list.ReserveNext(ipv6, prefix)
+	require.NoError(t, err)
+	return ipNet
+}
+
 func TestNewNetworkList(t *testing.T) {
 	_, workingBase, _ := net.ParseCIDR("10.224.0.0/24")
 	_, ipv6Base, _ := net.ParseCIDR("fd62:a80c:412::/64")
 	list := cmd.NewNetworkList(workingBase, ipv6Base)
-	first := list.ReserveNext(false)
-	second := list.ReserveNext(false)
-	third := list.ReserveNext(false)
-	forth := list.ReserveNext(true)
-	fifth := list.ReserveNext(true)
+	first := reserve(t, list, false, 0)
+	second := reserve(t, list, false, 0)
+	third := reserve(t, list, false, 0)
+	forth := reserve(t, list, true, 0)
+	fifth := reserve(t, list, true, 0)
 	assert.Equal(t, "10.224.0.0/24", first.String())
 	assert.Equal(t, "10.224.1.0/24", second.String())
 	assert.Equal(t, "10.224.2.0/24", third.String())
@@ -25,7 +35,50 @@ func TestNewNetworkList(t *testing.T) {
 	assert.Equal(t, "fd62:a80c:412:1::/64", fifth.String())
 	list.Free(second)
 	list.Free(forth)
-	assert.Equal(t, "10.224.1.0/24", list.ReserveNext(false).String())
-	assert.Equal(t, "10.224.3.0/24", list.ReserveNext(false).String())
-	assert.Equal(t, "fd62:a80c:412::/64", list.ReserveNext(true).String())
+	assert.Equal(t, "10.224.1.0/24", reserve(t, list, false, 0).String())
+	assert.Equal(t, "10.224.3.0/24", reserve(t, list, false, 0).String())
+	assert.Equal(t, "fd62:a80c:412::/64", reserve(t, list, true, 0).String())
+}
+
+func TestNetworkListPrefix(t *testing.T) {
+	_, workingBase, _ := net.ParseCIDR("10.224.0.0/24")
+	_, ipv6Base, _ := net.ParseCIDR("fd62:a80c:412::/64")
+	list := cmd.NewNetworkList(workingBase, ipv6Base)
+	assert.Equal(t, "10.224.0.0/26", reserve(t, list, false, 26).String())
+	// the default size is aligned again
+	assert.Equal(t, "10.224.1.0/24", reserve(t, list, false, 0).String())
+	small := reserve(t, list, false, 28)
+	assert.Equal(t, "10.224.2.0/28", small.String())
+	assert.Equal(t, "10.224.4.0/23", reserve(t, list, false, 23).String())
+	assert.Equal(t, "10.224.6.0/24", reserve(t, list, false, 0).String())
+	assert.Equal(t, "fd62:a80c:412::/56", reserve(t, list, true, 56).String())
+	assert.Equal(t, "fd62:a80c:412:100::/64", reserve(t, list, true, 0).String())
+
+	// freed networks are only reused for the same size
+	list.Free(small)
+	assert.Equal(t, "10.224.7.0/24", reserve(t, list, false, 0).String())
+	assert.Equal(t, "10.224.2.0/28", reserve(t, list, false, 28).String())
+}
+
+func TestNetworkListLimit(t *testing.T) {
+	_, workingBase, _ := net.ParseCIDR("10.224.0.0/24")
+	_, ipv6Base, _ := net.ParseCIDR("fd62:a80c:412::/64")
+	_, limitV4, _ := net.ParseCIDR("10.224.2.0/24")
+	list := cmd.NewNetworkList(workingBase, ipv6Base)
+	list.Limit(limitV4, nil)
+
+	assert.Equal(t, "10.224.0.0/24", reserve(t, list, false, 0).String())
+	_, err := list.ReserveNext(false, 23)
+	assert.Error(t, err, "network must not extend beyond the limit")
+	second := reserve(t, list, false, 0)
+	assert.Equal(t, "10.224.1.0/24", second.String())
+	_, err = list.ReserveNext(false, 0)
+	assert.Error(t, err, "subnets before the limit are exhausted")
+
+	// freed networks are still available
+	list.Free(second)
+	assert.Equal(t, "10.224.1.0/24", reserve(t, list, false, 0).String())
+
+	// IPv6 is unlimited
+	assert.Equal(t, "fd62:a80c:412::/64", reserve(t, list, true, 0).String())
 }
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// pauseControl keeps track of the runs that are paused by --pause-on-failure.
+// A paused run keeps its VMs and IDs until it is resumed.
+type pauseControl struct {
+	mu     sync.Mutex
+	paused map[string]chan struct{}
+}
+
+func newPauseControl() *pauseControl {
+	return &pauseControl{paused: make(map[string]chan struct{})}
+}
+
+// wait blocks until the run is resumed or the context is canceled.
+func (p *pauseControl) wait(ctx context.Context, testID string) {
+	resumed := make(chan struct{})
+	p.mu.Lock()
+	p.paused[testID] = resumed
+	p.mu.Unlock()
+
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.paused, testID)
+		p.mu.Unlock()
+	}
+}
+
+// resume resumes the paused run with the given test ID, or all paused runs if
+// the ID is empty. Returns the IDs of the resumed runs.
+func (p *pauseControl) resume(testID string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resumed := []string{}
+	for id, ch := range p.paused {
+		if testID == "" || testID == id {
+			close(ch)
+			delete(p.paused, id)
+			resumed = append(resumed, id)
+		}
+	}
+	sort.Strings(resumed)
+	return resumed
+}
+
+// pausedRuns returns the IDs of the paused runs.
+func (p *pauseControl) pausedRuns() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]string, 0, len(p.paused))
+	for id := range p.paused {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// watchInput resumes runs for each line that is read. An empty line resumes
+// all paused runs, otherwise the line is the ID of the run to resume.
+func (p *pauseControl) watchInput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.resumeLogged(strings.TrimSpace(scanner.Text()))
+	}
+}
+
+// watchSignals resumes all paused runs on SIGUSR2.
+func (p *pauseControl) watchSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			p.resumeLogged("")
+		}
+	}
+}
+
+func (p *pauseControl) resumeLogged(testID string) {
+	resumed := p.resume(testID)
+	if len(resumed) == 0 {
+		if testID != "" {
+			log.Warnf("PAUSE: Run %s is not paused", testID)
+		}
+		return
+	}
+	log.Infof("PAUSE: Resuming %s", strings.Join(resumed, ", "))
+}
+
+// pausesOnFailure returns whether the run is paused by --pause-on-failure.
+func pausesOnFailure(suiteRun *testSuiteRun, res *testResult) bool {
+	return suiteRun.pauses != nil && (res.status == StatusFailed || res.status == StatusFailedTimeout)
+}
+
+// pauseOnFailure keeps the VMs of a failed run until the run is resumed.
+func pauseOnFailure(ctx context.Context, suiteRun *testSuiteRun, run *testRun, res *testResult, testnodes []vmInstance) {
+	if !pausesOnFailure(suiteRun, res) {
+		return
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+
+	log.Warnf("PAUSE: Run %s failed, keeping its VMs for debugging", run.testID)
+	for _, vm := range testnodes {
+		log.Infof("PAUSE: %s: SSH with 'VIRTER_LIBVIRT_NETWORK=%s virter vm ssh %s', VNC on %s:%d",
+			vm.vmName(), vm.networkNames[0], vm.vmName(), host, 6000+vm.nr)
+	}
+	log.Infof("PAUSE: Press Enter to resume all paused runs, enter '%s' to resume this run or send SIGUSR2 to vmshed to resume all", run.testID)
+
+	suiteRun.pauses.wait(ctx, run.testID)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitPaused(t *testing.T, p *pauseControl, count int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return len(p.pausedRuns()) == count
+	}, time.Second, time.Millisecond)
+}
+
+func TestPauseControl(t *testing.T) {
+	p := newPauseControl()
+	done := make(chan string, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		go func(id string) {
+			p.wait(context.Background(), id)
+			done <- id
+		}(id)
+	}
+	waitPaused(t, p, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, p.pausedRuns())
+
+	// resume one run by ID, then all the others with an empty line
+	p.watchInput(strings.NewReader("b\nunknown\n"))
+	assert.Equal(t, "b", <-done)
+	assert.Equal(t, []string{"a", "c"}, p.pausedRuns())
+
+	assert.Equal(t, []string{"a", "c"}, p.resume(""))
+	<-done
+	<-done
+	assert.Empty(t, p.pausedRuns())
+}
+
+func TestPauseControlCanceled(t *testing.T) {
+	p := newPauseControl()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.wait(ctx, "a")
+		close(done)
+	}()
+	waitPaused(t, p, 1)
+
+	cancel()
+	<-done
+	assert.Empty(t, p.pausedRuns())
+}
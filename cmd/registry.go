@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Number of IPv4 subnets of the size of --first-subnet reserved for
+	// each registered instance.
+	registrySubnetsPerInstance = 32
+	// Number of IPv6 subnets of the size of --first-v6-subnet reserved for
+	// each registered instance. IPv6 networks often have shorter prefixes
+	// than the first subnet, so the block is much larger.
+	registryV6SubnetsPerInstance = 1 << 16
+)
+
+var instanceNameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// instancePrefix returns the prefix for the names of libvirt objects of the
+// given instance.
+func instancePrefix(instance string) string {
+	if instance == "" {
+		return ""
+	}
+	return instance + "-"
+}
+
+// instanceDescription returns a description of the instance for messages.
+func instanceDescription(instance string) string {
+	if instance == "" {
+		return "the default instance"
+	}
+	return "instance " + instance
+}
+
+func validateInstanceName(instance string) error {
+	if instance != "" && !instanceNameRegexp.MatchString(instance) {
+		return fmt.Errorf("invalid instance name %q, only letters, digits, '_' and '-' are allowed", instance)
+	}
+	return nil
+}
+
+// registryEntry describes the resources allocated to one vmshed instance on
+// this host.
+type registryEntry struct {
+	Instance    string `json:"instance"`
+	PID         int    `json:"pid"`
+	StartVM     int    `json:"start_vm"`
+	NrVMs       int    `json:"nr_vms"`
+	SubnetBlock int    `json:"subnet_block"`
+}
+
+// instanceRegistry is a file shared by all vmshed instances on a host which
+// records the VM IDs and subnets each instance uses. Access is serialized
+// with a lock file. The directory and the files are writable for all users,
+// so that the instances of several users can share the registry.
+type instanceRegistry struct {
+	dir string
+}
+
+func (r *instanceRegistry) lock() (*os.File, error) {
+	if _, err := os.Stat(r.dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(r.dir, 0777); err != nil {
+			return nil, err
+		}
+		// MkdirAll is subject to the umask
+		if err := os.Chmod(r.dir, 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := openShared(filepath.Join(r.dir, "registry.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock registry: %w", err)
+	}
+	return f, nil
+}
+
+// openShared opens the file for reading and writing. A new file is made
+// writable for all users regardless of the umask.
+func openShared(filename string) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if errors.Is(err, os.ErrExist) {
+		return os.OpenFile(filename, os.O_RDWR, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Chmod(0666); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *instanceRegistry) unlock(f *os.File) {
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	f.Close()
+}
+
+// entries reads the registry, dropping entries of processes which no longer
+// exist.
+func (r *instanceRegistry) entries() ([]registryEntry, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, "registry.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return []registryEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var all []registryEntry
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to decode registry: %w", err)
+	}
+
+	alive := []registryEntry{}
+	for _, e := range all {
+		if err := unix.Kill(e.PID, 0); err == nil || errors.Is(err, unix.EPERM) {
+			alive = append(alive, e)
+		}
+	}
+	return alive, nil
+}
+
+func (r *instanceRegistry) save(entries []registryEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(r.dir, "registry.json"), data, 0666)
+}
+
+// register allocates a range of nrVMs VM IDs, starting at minStartVM or
+// higher, and a block of subnets for the instance.
+func (r *instanceRegistry) register(instance string, minStartVM int, nrVMs int) (registryEntry, error) {
+	f, err := r.lock()
+	if err != nil {
+		return registryEntry{}, err
+	}
+	defer r.unlock(f)
+
+	entries, err := r.entries()
+	if err != nil {
+		return registryEntry{}, err
+	}
+
+	for _, e := range entries {
+		if e.Instance == instance {
+			return registryEntry{}, fmt.Errorf("%s is already in use by process %d", instanceDescription(instance), e.PID)
+		}
+	}
+
+	entry := registryEntry{
+		Instance:    instance,
+		PID:         os.Getpid(),
+		StartVM:     allocateVMRange(entries, minStartVM, nrVMs),
+		NrVMs:       nrVMs,
+		SubnetBlock: allocateSubnetBlock(entries),
+	}
+
+	if err := r.save(append(entries, entry)); err != nil {
+		return registryEntry{}, err
+	}
+	return entry, nil
+}
+
+// unregister removes the entry of this process.
+func (r *instanceRegistry) unregister(instance string) error {
+	f, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer r.unlock(f)
+
+	entries, err := r.entries()
+	if err != nil {
+		return err
+	}
+
+	remaining := []registryEntry{}
+	for _, e := range entries {
+		if e.Instance != instance || e.PID != os.Getpid() {
+			remaining = append(remaining, e)
+		}
+	}
+	return r.save(remaining)
+}
+
+// allocateVMRange returns the lowest start ID, at least minStartVM, such that
+// nrVMs IDs do not overlap with the ranges of the entries.
+func allocateVMRange(entries []registryEntry, minStartVM int, nrVMs int) int {
+	start := minStartVM
+	for {
+		moved := false
+		for _, e := range entries {
+			if start < e.StartVM+e.NrVMs && e.StartVM < start+nrVMs {
+				start = e.StartVM + e.NrVMs
+				moved = true
+			}
+		}
+		if !moved {
+			return start
+		}
+	}
+}
+
+func allocateSubnetBlock(entries []registryEntry) int {
+	used := map[int]bool{}
+	for _, e := range entries {
+		used[e.SubnetBlock] = true
+	}
+
+	block := 0
+	for used[block] {
+		block++
+	}
+	return block
+}
+
+// subnetBlockStart returns the first subnet of the given block of subnets
+// following first.
+func subnetBlockStart(first *net.IPNet, block int, subnetsPerBlock int64) (*net.IPNet, error) {
+	prefix, bits := first.Mask.Size()
+	ip := first.IP.To16()
+	if bits == 32 {
+		ip = first.IP.To4()
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(block)*subnetsPerBlock), uint(bits-prefix))
+	start := new(big.Int).Add(new(big.Int).SetBytes(ip), offset)
+	if start.BitLen() > bits {
+		return nil, fmt.Errorf("no subnets left for block %d after %s", block, first)
+	}
+	return &net.IPNet{IP: start.FillBytes(make([]byte, bits/8)), Mask: first.Mask}, nil
+}
+
+// registerInstance registers the instance with the host-wide registry and
+// adjusts the VM IDs and subnets of the suite run accordingly. It returns a
+// function to unregister the instance again.
+func registerInstance(registryDir string, suiteRun *testSuiteRun) (func(), error) {
+	registry := &instanceRegistry{dir: registryDir}
+	entry, err := registry.register(suiteRun.instance, suiteRun.startVM, suiteRun.nrVMs)
+	if err != nil {
+		return nil, err
+	}
+
+	unregister := func() {
+		if err := registry.unregister(suiteRun.instance); err != nil {
+			log.Warnf("Failed to unregister %s: %v", instanceDescription(suiteRun.instance), err)
+		}
+	}
+
+	firstV4Net, endV4Net, err := subnetBlock(suiteRun.firstV4Net, entry.SubnetBlock, registrySubnetsPerInstance)
+	if err != nil {
+		unregister()
+		return nil, err
+	}
+
+	firstV6Net, endV6Net, err := subnetBlock(suiteRun.firstV6Net, entry.SubnetBlock, registryV6SubnetsPerInstance)
+	if err != nil {
+		unregister()
+		return nil, err
+	}
+
+	log.Infof("INSTANCE: %s using VM IDs %d-%d and the subnets from %s and %s, ending before %s and %s",
+		instanceDescription(suiteRun.instance), entry.StartVM, entry.StartVM+entry.NrVMs-1,
+		firstV4Net, firstV6Net, endV4Net, endV6Net)
+
+	suiteRun.startVM = entry.StartVM
+	suiteRun.firstV4Net = firstV4Net
+	suiteRun.firstV6Net = firstV6Net
+	suiteRun.endV4Net = endV4Net
+	suiteRun.endV6Net = endV6Net
+	return unregister, nil
+}
+
+// subnetBlock returns the first subnet of the given block and the first
+// subnet after it.
+func subnetBlock(first *net.IPNet, block int, subnetsPerBlock int64) (*net.IPNet, *net.IPNet, error) {
+	start, err := subnetBlockStart(first, block, subnetsPerBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end, err := subnetBlockStart(first, block+1, subnetsPerBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return start, end, nil
+}
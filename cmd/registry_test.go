@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestAllocateVMRange(t *testing.T) {
+	entries := []registryEntry{
+		{StartVM: 2, NrVMs: 4},
+		{StartVM: 10, NrVMs: 2},
+	}
+
+	assert.Equal(t, 6, allocateVMRange(entries, 2, 4))
+	assert.Equal(t, 12, allocateVMRange(entries, 2, 5))
+	assert.Equal(t, 6, allocateVMRange(nil, 6, 5))
+	assert.Equal(t, 9, allocateVMRange(entries, 9, 1))
+	assert.Equal(t, 12, allocateVMRange(entries, 9, 2))
+}
+
+func TestInstanceRegistry(t *testing.T) {
+	registry := &instanceRegistry{dir: t.TempDir()}
+
+	a, err := registry.register("a", 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.StartVM)
+	assert.Equal(t, 0, a.SubnetBlock)
+
+	b, err := registry.register("b", 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 6, b.StartVM)
+	assert.Equal(t, 1, b.SubnetBlock)
+
+	_, err = registry.register("a", 2, 4)
+	assert.Error(t, err, "instance names must be unique")
+
+	require.NoError(t, registry.unregister("a"))
+
+	c, err := registry.register("c", 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.StartVM)
+	assert.Equal(t, 0, c.SubnetBlock)
+
+	d, err := registry.register("", 2, 2)
+	require.NoError(t, err, "the default instance is registered too")
+	assert.Equal(t, 4, d.StartVM)
+	assert.Equal(t, 2, d.SubnetBlock)
+
+	_, err = registry.register("", 2, 2)
+	assert.Error(t, err, "only one default instance may run")
+}
+
+func TestInstanceRegistryShared(t *testing.T) {
+	oldUmask := unix.Umask(0077)
+	defer unix.Umask(oldUmask)
+
+	registry := &instanceRegistry{dir: filepath.Join(t.TempDir(), "registry")}
+	_, err := registry.register("a", 2, 4)
+	require.NoError(t, err)
+
+	// other users must be able to register too
+	for name, perm := range map[string]os.FileMode{"": 0777, "registry.lock": 0666, "registry.json": 0666} {
+		info, err := os.Stat(filepath.Join(registry.dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, perm, info.Mode().Perm(), name)
+	}
+}
+
+func TestSubnetBlockStart(t *testing.T) {
+	_, first, _ := net.ParseCIDR("10.224.0.0/24")
+
+	block0, err := subnetBlockStart(first, 0, registrySubnetsPerInstance)
+	require.NoError(t, err)
+	assert.Equal(t, "10.224.0.0/24", block0.String())
+
+	block2, err := subnetBlockStart(first, 2, registrySubnetsPerInstance)
+	require.NoError(t, err)
+	assert.Equal(t, "10.224.64.0/24", block2.String())
+
+	start, end, err := subnetBlock(first, 1, registrySubnetsPerInstance)
+	require.NoError(t, err)
+	assert.Equal(t, "10.224.32.0/24", start.String())
+	assert.Equal(t, "10.224.64.0/24", end.String())
+
+	_, err = subnetBlockStart(first, 1<<20, registrySubnetsPerInstance)
+	assert.Error(t, err, "block beyond the address space")
+
+	_, firstV6, _ := net.ParseCIDR("fd62:a80c:412::/64")
+	start, end, err = subnetBlock(firstV6, 1, registryV6SubnetsPerInstance)
+	require.NoError(t, err)
+	assert.Equal(t, "fd62:a80c:413::/64", start.String())
+	assert.Equal(t, "fd62:a80c:414::/64", end.String())
+}
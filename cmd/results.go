@@ -10,19 +10,24 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func saveResultsJSON(suiteRun testSuiteRun, startTime time.Time, results map[string]testResult) error {
-	type resultData struct {
-		ID         string    `json:"id"`
-		Time       time.Time `json:"time"`
-		Name       string    `json:"name"`
-		VMCount    int       `json:"vm_count"`
-		Variant    string    `json:"variant"`
-		BaseImages []string  `json:"base_images"`
-		Status     string    `json:"status"`
-		Score      int       `json:"score"`
-		DurationNS int64     `json:"duration_ns"`
-	}
+// resultData is one line of results.json
+type resultData struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Name       string    `json:"name"`
+	VMCount    int       `json:"vm_count"`
+	Variant    string    `json:"variant"`
+	BaseImages []string  `json:"base_images"`
+	Status     string    `json:"status"`
+	Score      int       `json:"score"`
+	DurationNS int64     `json:"duration_ns"`
+	// Time at which the VMs of this run were started
+	Start time.Time `json:"start,omitzero"`
+	// Explanation of the status, such as the reason for disabling a test
+	Note string `json:"note,omitempty"`
+}
 
+func saveResultsJSON(suiteRun testSuiteRun, startTime time.Time, results map[string]testResult) error {
 	filename := filepath.Join(suiteRun.outDir, "results.json")
 	log.Infof("Saving results as JSON to %s", filename)
 	dest, err := os.Create(filename)
@@ -33,14 +38,27 @@ func saveResultsJSON(suiteRun testSuiteRun, startTime time.Time, results map[str
 
 	enc := json.NewEncoder(dest)
 
+	for _, data := range resultRecords(suiteRun, startTime, results) {
+		if err := enc.Encode(&data); err != nil {
+			return fmt.Errorf("failed to encode results JSON: %w", err)
+		}
+	}
+
+	return dest.Sync()
+}
+
+// resultRecords returns the results of the test runs that were carried out.
+func resultRecords(suiteRun testSuiteRun, startTime time.Time, results map[string]testResult) []resultData {
+	records := []resultData{}
 	for _, testRun := range suiteRun.testRuns {
 		result, ok := results[testRun.testID]
 		if !ok {
 			// exclude runs that were skipped entirely
 			continue
 		}
-		if result.status == StatusCanceled || result.status == StatusSkipped {
-			// exclude canceled runs and those that were skipped while running
+		if result.status == StatusCanceled || (result.status == StatusSkipped && testRun.disabled == "") {
+			// exclude canceled runs and those that were skipped while
+			// running, but keep disabled tests visible
 			continue
 		}
 
@@ -55,14 +73,55 @@ func saveResultsJSON(suiteRun testSuiteRun, startTime time.Time, results map[str
 			Status:     string(result.status),
 			Score:      statusScore(result.status),
 			DurationNS: result.execTime.Nanoseconds(),
+			Start:      result.startTime,
+			Note:       secrets.mask(result.note),
 		}
+		records = append(records, data)
+	}
+	return records
+}
 
-		if err := enc.Encode(&data); err != nil {
-			return fmt.Errorf("failed to encode results JSON: %w", err)
+// loadResultsJSON reads the results from a results.json file.
+func loadResultsJSON(filename string) ([]resultData, error) {
+	src, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results JSON file: %w", err)
+	}
+	defer src.Close()
+
+	results := []resultData{}
+	dec := json.NewDecoder(src)
+	for dec.More() {
+		var data resultData
+		if err := dec.Decode(&data); err != nil {
+			return nil, fmt.Errorf("failed to decode results JSON file %s: %w", filename, err)
 		}
+		results = append(results, data)
 	}
 
-	return dest.Sync()
+	return results, nil
+}
+
+// loadVMUsage counts how often each VM was used in the given results.json
+// files.
+func loadVMUsage(filenames []string) (map[string]int, error) {
+	usage := make(map[string]int)
+	for _, filename := range filenames {
+		results, err := loadResultsJSON(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, data := range results {
+			if data.Status == string(StatusSkipped) {
+				continue
+			}
+			for _, image := range data.BaseImages {
+				usage[image]++
+			}
+		}
+	}
+	return usage, nil
 }
 
 func baseImageNames(vms []vm) []string {
@@ -74,7 +133,7 @@ func baseImageNames(vms []vm) []string {
 }
 
 func statusScore(s TestStatus) int {
-	if s == StatusSuccess {
+	if s == StatusSuccess || s == StatusXPass {
 		return 1
 	}
 	return 0
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"os/exec"
+	"strings"
 	"text/template"
 	"time"
 
@@ -15,16 +16,23 @@ import (
 type networkStage string
 
 const (
-	networkAdd   networkStage = "Add"
-	networkReady networkStage = "Ready"
-	networkBusy  networkStage = "Busy"
-	networkError networkStage = "Error"
+	networkAdd    networkStage = "Add"
+	networkReady  networkStage = "Ready"
+	networkBusy   networkStage = "Busy"
+	networkRemove networkStage = "Remove"
+	networkError  networkStage = "Error"
+	// The network was not created because no subnet was free
+	networkNoSubnet networkStage = "NoSubnet"
+	// Removing the network failed, it is removed again at the end
+	networkRemoveError networkStage = "RemoveError"
 )
 
 type networkState struct {
 	network  virterNet
 	isAccess bool
 	stage    networkStage
+	ipv4Net  *net.IPNet
+	ipv6Net  *net.IPNet
 }
 
 type pullStage string
@@ -55,15 +63,48 @@ const (
 
 type suiteState struct {
 	networks map[string]*networkState
+	// Index used for the name of the next network
+	nextNetworkID int
 	// Indexed by base image
 	pullStage map[string]pullStage
 	// Indexed by VM ID
 	provisionStage map[string]provisionStage
 	runStage       map[string]runStage
 	runResults     map[string]testResult
-	freeIDs        map[int]bool
-	freeNets       *networkList
-	errors         []error
+	// Indexed by test ID, only for runs in runExec
+	runResources map[string]runResources
+	// Indexed by VM ID, only for images in provisionExec
+	provisionResources map[string]provisionResources
+	// Indexed by test name
+	activeRuns map[string]int
+	freeIDs    map[int]bool
+	// Number of VM IDs to use in parallel, at most --nvms
+	nvms int
+	// Cancels a running test, indexed by test ID, only for runs in runExec
+	runCancel map[string]context.CancelCauseFunc
+	freeNets  *networkList
+	errors    []error
+	// Number of runs determined before the scheduler started, the names of
+	// their tests in order and the index of the next test to repeat for
+	// --fill
+	plannedRuns int
+	fillTests   []string
+	fillNext    int
+	// Namespace for network names
+	instance string
+}
+
+// runResources are the VM IDs and networks held by a run
+type runResources struct {
+	ids          []int
+	networkNames []string
+	start        time.Time
+}
+
+// provisionResources are the VM ID and network used to provision an image
+type provisionResources struct {
+	id          int
+	networkName string
 }
 
 type action interface {
@@ -82,9 +123,15 @@ type action interface {
 
 func runScheduler(ctx context.Context, suiteRun *testSuiteRun) map[string]testResult {
 	state := initializeState(suiteRun)
+	publishState(suiteRun, state)
 	defer tearDown(suiteRun, state)
 
 	scheduleLoop(ctx, suiteRun, state)
+	publishState(suiteRun, state)
+
+	if suiteRun.untilFailure {
+		logUntilFailureResult(suiteRun, state)
+	}
 
 	nErrs := len(state.errors)
 	if nErrs == 0 {
@@ -103,18 +150,40 @@ func runScheduler(ctx context.Context, suiteRun *testSuiteRun) map[string]testRe
 
 func initializeState(suiteRun *testSuiteRun) *suiteState {
 	netlist := NewNetworkList(suiteRun.firstV4Net, suiteRun.firstV6Net)
+	netlist.Limit(suiteRun.endV4Net, suiteRun.endV6Net)
 
 	state := suiteState{
-		networks:       make(map[string]*networkState),
-		pullStage:      make(map[string]pullStage),
-		provisionStage: make(map[string]provisionStage),
-		runStage:       make(map[string]runStage),
-		runResults:     make(map[string]testResult),
-		freeIDs:        make(map[int]bool),
-		freeNets:       netlist,
+		networks:           make(map[string]*networkState),
+		pullStage:          make(map[string]pullStage),
+		provisionStage:     make(map[string]provisionStage),
+		runStage:           make(map[string]runStage),
+		runResults:         make(map[string]testResult),
+		runResources:       make(map[string]runResources),
+		provisionResources: make(map[string]provisionResources),
+		activeRuns:         make(map[string]int),
+		freeIDs:            make(map[int]bool),
+		nvms:               suiteRun.nrVMs,
+		runCancel:          make(map[string]context.CancelCauseFunc),
+		freeNets:           netlist,
+		plannedRuns:        len(suiteRun.testRuns),
+		instance:           suiteRun.instance,
 	}
 	for _, run := range suiteRun.testRuns {
 		state.runStage[run.testID] = runNew
+		if !containsString(state.fillTests, run.testName) {
+			state.fillTests = append(state.fillTests, run.testName)
+		}
+	}
+	for testID, res := range suiteRun.resumedResults {
+		state.runStage[testID] = runDone
+		state.runResults[testID] = res
+	}
+	for i := range suiteRun.testRuns {
+		run := &suiteRun.testRuns[i]
+		if _, ok := state.runResults[run.testID]; !ok && run.disabled != "" {
+			state.runStage[run.testID] = runDone
+			state.runResults[run.testID] = disabledResult(run)
+		}
 	}
 
 	initialPullStage := pullNone
@@ -145,6 +214,7 @@ func scheduleLoop(ctx context.Context, suiteRun *testSuiteRun, state *suiteState
 	results := make(chan action)
 	activeActions := 0
 	softExpired := false
+	stopped := false // by a control command
 
 	var softTimerC <-chan time.Time
 	if suiteRun.timeoutSoft > 0 {
@@ -155,31 +225,53 @@ func scheduleLoop(ctx context.Context, suiteRun *testSuiteRun, state *suiteState
 
 	for {
 		for {
-			if softExpired || runStopping(suiteRun, state) || ctx.Err() != nil {
+			if softExpired || stopped || runStopping(suiteRun, state) || ctx.Err() != nil {
 				break
 			}
 
 			nextAction := chooseNextAction(suiteRun, state)
+			if nextAction == nil && suiteRun.fill && addFillRun(suiteRun, state) {
+				nextAction = chooseNextAction(suiteRun, state)
+			}
 			if nextAction == nil {
 				break
 			}
 
 			log.Debugln("SCHEDULE: Perform action:", nextAction.name())
 			nextAction.updatePre(state)
+			persistState(suiteRun, state)
 			activeActions++
-			go func(a action) {
+
+			actionCtx := ctx
+			if testAction, ok := nextAction.(*performTestAction); ok {
+				var cancelRun context.CancelCauseFunc
+				actionCtx, cancelRun = context.WithCancelCause(ctx)
+				state.runCancel[testAction.run.testID] = cancelRun
+			}
+
+			go func(ctx context.Context, a action) {
 				a.exec(ctx, suiteRun)
 				results <- a
-			}(nextAction)
+			}(actionCtx, nextAction)
 		}
 
 		if activeActions == 0 {
 			if !softExpired {
+				interrupted := canceledByUser(ctx)
 				for _, run := range suiteRun.testRuns {
-					if state.runStage[run.testID] != runDone {
-						state.runResults[run.testID] = testResult{status: StatusSkipped, err: fmt.Errorf("skipped")}
-						state.errors = append(state.errors, fmt.Errorf("Skipped test run: %s", run.testID))
+					if state.runStage[run.testID] == runDone {
+						continue
+					}
+					if interrupted {
+						state.runResults[run.testID] = testResult{status: StatusCanceled, err: fmt.Errorf("canceled"), userCanceled: true}
+						continue
 					}
+					if stopped {
+						state.runResults[run.testID] = notStartedResult("not started: stopped by control command")
+						continue
+					}
+					state.runResults[run.testID] = testResult{status: StatusSkipped, err: fmt.Errorf("skipped")}
+					state.errors = append(state.errors, fmt.Errorf("Skipped test run: %s", run.testID))
 				}
 			}
 			break
@@ -191,10 +283,21 @@ func scheduleLoop(ctx context.Context, suiteRun *testSuiteRun, state *suiteState
 			activeActions--
 			log.Debugln("SCHEDULE: Apply result for:", r.name())
 			r.updatePost(state)
+			if testAction, ok := r.(*performTestAction); ok {
+				state.runCancel[testAction.run.testID](nil)
+				delete(state.runCancel, testAction.run.testID)
+			}
+			if suiteRun.untilFailure && !softExpired && !stopped {
+				repeatSuccessfulRun(suiteRun, state, r)
+			}
+			persistState(suiteRun, state)
 		case <-softTimerC:
 			softTimerC = nil
 			softExpired = true
 			log.Infof("STATUS: Soft timeout reached, no new tests will be scheduled")
+		case command := <-suiteRun.controlCommands:
+			command.reply <- handleControlCommand(suiteRun, state, command.request, &stopped)
+			persistState(suiteRun, state)
 		}
 
 		if runStopping(suiteRun, state) {
@@ -203,17 +306,135 @@ func scheduleLoop(ctx context.Context, suiteRun *testSuiteRun, state *suiteState
 	}
 }
 
+// persistState saves the state so that the test suite run can be resumed
+// after vmshed is killed, and publishes it for state dumps.
+func persistState(suiteRun *testSuiteRun, state *suiteState) {
+	publishState(suiteRun, state)
+	if suiteRun.outDir == "" {
+		return
+	}
+
+	if err := saveState(suiteRun, state); err != nil {
+		log.Warnf("Failed to save state: %v", err)
+	}
+}
+
+// repeatSuccessfulRun adds another run of the same test when a test run
+// succeeds in --until-failure mode.
+func repeatSuccessfulRun(suiteRun *testSuiteRun, state *suiteState, a action) {
+	testAction, ok := a.(*performTestAction)
+	if !ok || testAction.res.err != nil || runStopping(suiteRun, state) {
+		return
+	}
+
+	run, err := repeatTestRun(suiteRun.randomGenerator, suiteRun.testRuns, testAction.run)
+	if err != nil {
+		state.errors = append(state.errors, fmt.Errorf("repeat %s: %w", testAction.run.testID, err))
+		return
+	}
+
+	log.Debugf("SCHEDULE: Add run %s", run.testID)
+	suiteRun.testRuns = append(suiteRun.testRuns, run)
+	state.runStage[run.testID] = runNew
+}
+
+// addFillRun adds a randomized run for --fill once all other runs have
+// started. The planned runs are used as templates in turn. Returns whether a
+// run was added.
+func addFillRun(suiteRun *testSuiteRun, state *suiteState) bool {
+	if availableIDs(suiteRun, state) < 1 {
+		return false
+	}
+
+	for _, run := range suiteRun.testRuns {
+		if state.runStage[run.testID] == runNew {
+			return false
+		}
+	}
+
+	// Take the tests in turn, regardless of how many runs each has
+	for i := 0; i < len(state.fillTests); i++ {
+		testName := state.fillTests[state.fillNext%len(state.fillTests)]
+		state.fillNext++
+
+		templates := []*testRun{}
+		for j := 0; j < state.plannedRuns; j++ {
+			candidate := &suiteRun.testRuns[j]
+			if candidate.testName == testName && len(candidate.vms) <= suiteRun.nrVMs && candidate.disabled == "" {
+				templates = append(templates, candidate)
+			}
+		}
+		if len(templates) == 0 {
+			continue
+		}
+		template := templates[suiteRun.randomGenerator.Intn(len(templates))]
+
+		run, err := repeatTestRun(suiteRun.randomGenerator, suiteRun.testRuns, template)
+		if err != nil {
+			state.errors = append(state.errors, fmt.Errorf("repeat %s: %w", template.testID, err))
+			return false
+		}
+
+		log.Debugf("SCHEDULE: Add run %s", run.testID)
+		suiteRun.testRuns = append(suiteRun.testRuns, run)
+		state.runStage[run.testID] = runNew
+		return true
+	}
+
+	return false
+}
+
+func logUntilFailureResult(suiteRun *testSuiteRun, state *suiteState) {
+	successful := 0
+	failed := []string{}
+	for _, run := range suiteRun.testRuns {
+		res, ok := state.runResults[run.testID]
+		if !ok {
+			continue
+		}
+
+		switch res.status {
+		case StatusSuccess, StatusXPass:
+			successful++
+		case StatusFailed, StatusFailedTimeout, StatusError:
+			failed = append(failed, run.testID)
+		}
+	}
+
+	if len(failed) == 0 {
+		log.Infof("STATUS: %d successful iterations without failure", successful)
+	} else {
+		log.Infof("STATUS: %d successful iterations before failure of %s", successful, strings.Join(failed, ", "))
+	}
+}
+
 func tearDown(suiteRun *testSuiteRun, state *suiteState) {
 	if len(state.errors) > 0 && suiteRun.onFailure == OnFailureKeepVms {
 		log.Warn("There were errors, not removing network")
 		log.Info("Use \"virter network rm ...\" to remove networks when done")
 		return
 	}
-	for networkName := range state.networks {
+	for networkName, ns := range state.networks {
+		if ns.stage == networkNoSubnet {
+			continue
+		}
 		err := removeNetwork(suiteRun.outDir, networkName)
 		if err != nil {
 			state.errors = append(state.errors, err)
+			suiteRun.cleanupFailures.add(fmt.Errorf("remove network %s: %w", networkName, err))
+			continue
 		}
+		delete(state.networks, networkName)
+	}
+	// Keep only the networks that still exist in the state file, so that
+	// cleanup and --resume do not try to remove them again
+	persistState(suiteRun, state)
+}
+
+// publishState passes a snapshot of the state to the state dumper.
+func publishState(suiteRun *testSuiteRun, state *suiteState) {
+	if suiteRun.dumps != nil {
+		suiteRun.dumps.publish(takeSnapshot(suiteRun, state))
 	}
 }
 
@@ -223,7 +444,7 @@ func runStopping(suiteRun *testSuiteRun, state *suiteState) bool {
 	}
 
 	for _, netState := range state.networks {
-		if netState.stage == networkError {
+		if netState.stage == networkError || netState.stage == networkNoSubnet {
 			return true
 		}
 	}
@@ -242,7 +463,11 @@ func runStopping(suiteRun *testSuiteRun, state *suiteState) bool {
 }
 
 func chooseNextAction(suiteRun *testSuiteRun, state *suiteState) action {
-	if len(state.freeIDs) < 1 {
+	if action := nextActionRemoveNetwork(suiteRun, state); action != nil {
+		return action
+	}
+
+	if availableIDs(suiteRun, state) < 1 {
 		return nil
 	}
 
@@ -272,6 +497,10 @@ func chooseNextAction(suiteRun *testSuiteRun, state *suiteState) action {
 			continue
 		}
 
+		if run.maxParallel > 0 && state.activeRuns[run.testName] >= run.maxParallel {
+			continue
+		}
+
 		if runBetter(state, bestRun, run) {
 			bestRun = &suiteRun.testRuns[i]
 		}
@@ -322,7 +551,7 @@ func allImagesReady(state *suiteState, run *testRun) bool {
 }
 
 func allNetworksReady(state *suiteState, run *testRun) bool {
-	networkName := findReadyNetwork(state, nil, accessNetwork(run.variant.IPv6), true)
+	networkName := findReadyNetwork(state, nil, accessNetwork(run.variant), true)
 	if networkName == "" {
 		return false
 	}
@@ -352,8 +581,8 @@ func findExtraNetworks(state *suiteState, run *testRun) ([]string, []virterNet)
 }
 
 func findReadyNetwork(state *suiteState, exclude map[string]bool, network virterNet, access bool) string {
-	for i := 0; i < len(state.networks); i++ {
-		networkName := generateNetworkName(i, access)
+	for i := 0; i < state.nextNetworkID; i++ {
+		networkName := generateNetworkName(state.instance, i, access)
 
 		ns, ok := state.networks[networkName]
 		if !ok {
@@ -368,10 +597,7 @@ func findReadyNetwork(state *suiteState, exclude map[string]bool, network virter
 			continue
 		}
 
-		if ns.network.ForwardMode != network.ForwardMode ||
-			ns.network.DHCP != network.DHCP ||
-			ns.network.Domain != network.Domain ||
-			ns.network.IPv6 != network.IPv6 {
+		if !sameNetwork(ns.network, network) {
 			continue
 		}
 
@@ -384,8 +610,25 @@ func findReadyNetwork(state *suiteState, exclude map[string]bool, network virter
 	return ""
 }
 
+// sameNetwork returns whether a network created for a can be used for b.
+func sameNetwork(a, b virterNet) bool {
+	return a.ForwardMode == b.ForwardMode &&
+		a.DHCP == b.DHCP &&
+		a.Domain == b.Domain &&
+		a.hasIPv4() == b.hasIPv4() &&
+		a.IPv6 == b.IPv6 &&
+		a.IPv4Prefix == b.IPv4Prefix &&
+		a.IPv6Prefix == b.IPv6Prefix
+}
+
+// availableIDs returns the number of free IDs that may be used. When fewer
+// VMs than --nvms are to be used, some of the free IDs are held back.
+func availableIDs(suiteRun *testSuiteRun, state *suiteState) int {
+	return len(state.freeIDs) - (suiteRun.nrVMs - state.nvms)
+}
+
 func countNonTestIDs(suiteRun *testSuiteRun, state *suiteState) int {
-	nonTestIDs := suiteRun.nrVMs
+	nonTestIDs := state.nvms
 
 	for _, run := range suiteRun.testRuns {
 		if state.runStage[run.testID] == runExec {
@@ -397,7 +640,7 @@ func countNonTestIDs(suiteRun *testSuiteRun, state *suiteState) int {
 }
 
 func nextActionRun(suiteRun *testSuiteRun, state *suiteState, run *testRun) action {
-	if len(state.freeIDs) < len(run.vms) {
+	if availableIDs(suiteRun, state) < len(run.vms) {
 		return nil
 	}
 
@@ -405,15 +648,15 @@ func nextActionRun(suiteRun *testSuiteRun, state *suiteState, run *testRun) acti
 		return nil
 	}
 
-	network := accessNetwork(run.variant.IPv6)
+	network := accessNetwork(run.variant)
 	networkName := findReadyNetwork(state, nil, network, true)
 	if networkName == "" {
-		return makeAddNetworkAction(state, network, true)
+		return makeAddNetworkAction(suiteRun, state, network, true)
 	}
 
 	networkNames, remainingNetworks := findExtraNetworks(state, run)
 	if len(remainingNetworks) > 0 {
-		return makeAddNetworkAction(state, remainingNetworks[0], false)
+		return makeAddNetworkAction(suiteRun, state, remainingNetworks[0], false)
 	}
 
 	ids := getIDs(suiteRun, state, len(run.vms))
@@ -446,17 +689,62 @@ func nextActionPull(suiteRun *testSuiteRun, v *vm) action {
 }
 
 func nextActionProvision(suiteRun *testSuiteRun, state *suiteState, v *vm) action {
-	network := accessNetwork(false)
+	network := accessNetwork(variant{})
 	networkName := findReadyNetwork(state, nil, network, true)
 	if networkName == "" {
-		return makeAddNetworkAction(state, network, true)
+		return makeAddNetworkAction(suiteRun, state, network, true)
 	}
 
 	ids := getIDs(suiteRun, state, 1)
 	return &provisionImageAction{v: v, id: ids[0], networkName: networkName}
 }
 
-func makeAddNetworkAction(state *suiteState, network virterNet, access bool) action {
+// nextActionRemoveNetwork returns an action to remove an idle extra network
+// when no pending run needs a network of that kind. Networks are left for
+// tearDown when no runs are pending.
+func nextActionRemoveNetwork(suiteRun *testSuiteRun, state *suiteState) action {
+	pending := false
+	neededNetworks := []virterNet{}
+	for _, run := range suiteRun.testRuns {
+		if state.runStage[run.testID] == runNew {
+			pending = true
+			neededNetworks = append(neededNetworks, run.networks...)
+		}
+	}
+
+	if !pending {
+		return nil
+	}
+
+	for i := 0; i < state.nextNetworkID; i++ {
+		networkName := generateNetworkName(state.instance, i, false)
+
+		ns, ok := state.networks[networkName]
+		if !ok || ns.stage != networkReady {
+			continue
+		}
+
+		if !networkNeeded(neededNetworks, ns.network) {
+			return &removeNetworkAction{networkName: networkName}
+		}
+	}
+	return nil
+}
+
+func networkNeeded(neededNetworks []virterNet, network virterNet) bool {
+	for _, needed := range neededNetworks {
+		if sameNetwork(network, needed) {
+			return true
+		}
+	}
+	return false
+}
+
+func makeAddNetworkAction(suiteRun *testSuiteRun, state *suiteState, network virterNet, access bool) action {
+	if suiteRun.maxNetworks > 0 && len(state.networks) >= suiteRun.maxNetworks {
+		return nil
+	}
+
 	// Due to https://gitlab.com/libvirt/libvirt/-/issues/78 only one addNetworkAction should run at a time.
 	// Basically, libvirt could potentially generate the same bridge name twice, which results in unusable networks.
 	for _, ns := range state.networks {
@@ -466,19 +754,19 @@ func makeAddNetworkAction(state *suiteState, network virterNet, access bool) act
 	}
 
 	return &addNetworkAction{
-		networkName: generateNetworkName(len(state.networks), access),
+		networkName: generateNetworkName(state.instance, state.nextNetworkID, access),
 		network:     network,
 		access:      access,
 	}
 }
 
-func generateNetworkName(id int, access bool) string {
+func generateNetworkName(instance string, id int, access bool) string {
 	networkType := "extra"
 	if access {
 		networkType = "access"
 	}
 
-	return fmt.Sprintf("vmshed-%d-%s", id, networkType)
+	return fmt.Sprintf("%svmshed-%d-%s", instancePrefix(instance), id, networkType)
 }
 
 func deleteAll(m map[int]bool, ints []int) {
@@ -501,6 +789,8 @@ func (a *performTestAction) name() string {
 
 func (a *performTestAction) updatePre(state *suiteState) {
 	state.runStage[a.run.testID] = runExec
+	state.runResources[a.run.testID] = runResources{ids: a.ids, networkNames: a.networkNames, start: time.Now()}
+	state.activeRuns[a.run.testName]++
 	deleteAll(state.freeIDs, a.ids)
 	for _, networkName := range a.networkNames {
 		state.networks[networkName].stage = networkBusy
@@ -519,6 +809,8 @@ func (a *performTestAction) updatePost(state *suiteState) {
 	}
 
 	state.runStage[a.run.testID] = runDone
+	delete(state.runResources, a.run.testID)
+	state.activeRuns[a.run.testName]--
 	state.runResults[a.run.testID] = a.res
 	if a.res.err != nil {
 		state.errors = append(state.errors,
@@ -572,6 +864,7 @@ func (a *provisionImageAction) name() string {
 
 func (a *provisionImageAction) updatePre(state *suiteState) {
 	state.provisionStage[a.v.ID()] = provisionExec
+	state.provisionResources[a.v.ID()] = provisionResources{id: a.id, networkName: a.networkName}
 	delete(state.freeIDs, a.id)
 	state.networks[a.networkName].stage = networkBusy
 }
@@ -583,6 +876,7 @@ func (a *provisionImageAction) exec(ctx context.Context, suiteRun *testSuiteRun)
 func (a *provisionImageAction) updatePost(state *suiteState) {
 	state.networks[a.networkName].stage = networkReady
 	state.freeIDs[a.id] = true
+	delete(state.provisionResources, a.v.ID())
 	if a.err == nil {
 		log.Infof("STATUS: Successfully provisioned %s", a.v.ID())
 		state.provisionStage[a.v.ID()] = provisionDone
@@ -607,20 +901,37 @@ func (a *addNetworkAction) name() string {
 }
 
 func (a *addNetworkAction) updatePre(state *suiteState) {
+	if a.network.DHCP {
+		if a.network.hasIPv4() {
+			a.ipv4Net, a.err = state.freeNets.ReserveNext(false, a.network.IPv4Prefix)
+		}
+		if a.err == nil && a.network.IPv6 {
+			a.ipv6Net, a.err = state.freeNets.ReserveNext(true, a.network.IPv6Prefix)
+		}
+	}
+
+	stage := networkAdd
+	if a.err != nil {
+		state.freeNets.Free(a.ipv4Net)
+		a.ipv4Net = nil
+		stage = networkNoSubnet
+	}
+
 	state.networks[a.networkName] = &networkState{
 		network:  a.network,
 		isAccess: a.access,
-		stage:    networkAdd,
-	}
-	if a.network.DHCP {
-		a.ipv4Net = state.freeNets.ReserveNext(false)
-		if a.network.IPv6 {
-			a.ipv6Net = state.freeNets.ReserveNext(true)
-		}
+		stage:    stage,
+		ipv4Net:  a.ipv4Net,
+		ipv6Net:  a.ipv6Net,
 	}
+	state.nextNetworkID++
 }
 
 func (a *addNetworkAction) exec(ctx context.Context, suiteRun *testSuiteRun) {
+	if a.err != nil {
+		return
+	}
+
 	dhcpCount := 0
 	if a.access {
 		dhcpCount = suiteRun.nrVMs
@@ -629,14 +940,54 @@ func (a *addNetworkAction) exec(ctx context.Context, suiteRun *testSuiteRun) {
 }
 
 func (a *addNetworkAction) updatePost(state *suiteState) {
+	ns := state.networks[a.networkName]
+	if ns.stage == networkNoSubnet {
+		state.errors = append(state.errors,
+			fmt.Errorf("add network %s: no subnet left for this instance, use --max-networks to limit the number of networks: %w", a.networkName, a.err))
+		return
+	}
+
 	if a.err != nil {
 		state.errors = append(state.errors,
 			fmt.Errorf("add network %s: %w", a.networkName, a.err))
-		state.networks[a.networkName].stage = networkError
+		ns.stage = networkError
 		return
 	}
 
-	state.networks[a.networkName].stage = networkReady
+	ns.stage = networkReady
+}
+
+type removeNetworkAction struct {
+	networkName string
+	err         error
+}
+
+func (a *removeNetworkAction) name() string {
+	return fmt.Sprintf("Remove network %s", a.networkName)
+}
+
+func (a *removeNetworkAction) updatePre(state *suiteState) {
+	state.networks[a.networkName].stage = networkRemove
+}
+
+func (a *removeNetworkAction) exec(ctx context.Context, suiteRun *testSuiteRun) {
+	a.err = removeNetwork(suiteRun.outDir, a.networkName)
+}
+
+func (a *removeNetworkAction) updatePost(state *suiteState) {
+	ns := state.networks[a.networkName]
+	if a.err != nil {
+		// The network may still exist, so it is kept in the state and
+		// its subnets cannot be reused
+		ns.stage = networkRemoveError
+		state.errors = append(state.errors,
+			fmt.Errorf("remove network %s: %w", a.networkName, a.err))
+		return
+	}
+
+	delete(state.networks, a.networkName)
+	state.freeNets.Free(ns.ipv4Net)
+	state.freeNets.Free(ns.ipv6Net)
 }
 
 func unwrapStderr(err error) {
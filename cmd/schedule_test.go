@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"errors"
+	"math/rand"
 	"net"
 	"reflect"
 	"testing"
@@ -55,6 +56,35 @@ func TestChooseNextAction(t *testing.T) {
 		testID: "tSameB",
 		vms:    []vm{vmSameB},
 	}
+	testRunLimited0 := testRun{
+		testName:    "limited",
+		testID:      "tLimited0",
+		vms:         []vm{vm0},
+		maxParallel: 1,
+	}
+	testRunLimited1 := testRun{
+		testName:    "limited",
+		testID:      "tLimited1",
+		vms:         []vm{vm0},
+		maxParallel: 1,
+	}
+	testRunOther := testRun{
+		testName: "other",
+		testID:   "tOther",
+		vms:      []vm{vm0},
+	}
+	netA := virterNet{ForwardMode: "nat", DHCP: true, Domain: "a"}
+	netB := virterNet{ForwardMode: "nat", DHCP: true, Domain: "b"}
+	testRunNetA := testRun{
+		testID:   "tNetA",
+		vms:      []vm{vm0},
+		networks: []virterNet{netA},
+	}
+	testRunNetB := testRun{
+		testID:   "tNetB",
+		vms:      []vm{vm0},
+		networks: []virterNet{netB},
+	}
 
 	type step struct {
 		result action
@@ -271,6 +301,112 @@ func TestChooseNextAction(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "max-parallel",
+			suiteRun: testSuiteRun{
+				vmSpec:     &vmSpecification{VMs: []vm{vm0}},
+				testRuns:   []testRun{testRunLimited0, testRunLimited1, testRunOther},
+				startVM:    5,
+				nrVMs:      2,
+				firstV4Net: baseNet,
+			},
+			sequence: []step{
+				{
+					expected: []action{accessNetworkAction(networkName0)},
+				},
+				{
+					result: accessNetworkAction(networkName0),
+					expected: []action{
+						&performTestAction{run: &testRunLimited0, ids: []int{5}, networkNames: networkNames0},
+						accessNetworkAction(networkName1),
+					},
+				},
+				{
+					result: accessNetworkAction(networkName1),
+					// only one run of "limited" at a time, fill the other ID with a different test
+					expected: []action{&performTestAction{run: &testRunOther, ids: []int{6}, networkNames: networkNames1}},
+				},
+				{
+					result: &performTestAction{run: &testRunOther, ids: []int{6}, networkNames: networkNames1},
+					// the limit is still reached, so the free ID stays unused
+				},
+				{
+					result:   &performTestAction{run: &testRunLimited0, ids: []int{5}, networkNames: networkNames0},
+					expected: []action{&performTestAction{run: &testRunLimited1, ids: []int{5}, networkNames: networkNames0}},
+				},
+			},
+		},
+		{
+			name: "release-extra-network",
+			suiteRun: testSuiteRun{
+				vmSpec:      &vmSpecification{VMs: []vm{vm0}},
+				testRuns:    []testRun{testRunNetA, testRunNetB},
+				startVM:     5,
+				nrVMs:       1,
+				firstV4Net:  baseNet,
+				maxNetworks: 2,
+			},
+			sequence: []step{
+				{
+					expected: []action{accessNetworkAction(networkName0)},
+				},
+				{
+					result:   accessNetworkAction(networkName0),
+					expected: []action{&addNetworkAction{networkName: "vmshed-1-extra", network: netA}},
+				},
+				{
+					result:   &addNetworkAction{networkName: "vmshed-1-extra", network: netA},
+					expected: []action{&performTestAction{run: &testRunNetA, ids: []int{5}, networkNames: []string{networkName0, "vmshed-1-extra"}}},
+				},
+				{
+					result: &performTestAction{run: &testRunNetA, ids: []int{5}, networkNames: []string{networkName0, "vmshed-1-extra"}},
+					// no pending run needs netA and the limit prevents adding netB
+					expected: []action{&removeNetworkAction{networkName: "vmshed-1-extra"}},
+				},
+				{
+					result:   &removeNetworkAction{networkName: "vmshed-1-extra"},
+					expected: []action{&addNetworkAction{networkName: "vmshed-2-extra", network: netB}},
+				},
+				{
+					result:   &addNetworkAction{networkName: "vmshed-2-extra", network: netB},
+					expected: []action{&performTestAction{run: &testRunNetB, ids: []int{5}, networkNames: []string{networkName0, "vmshed-2-extra"}}},
+				},
+			},
+		},
+		{
+			name: "remove-extra-network-fails",
+			suiteRun: testSuiteRun{
+				vmSpec:      &vmSpecification{VMs: []vm{vm0}},
+				testRuns:    []testRun{testRunNetA, testRunNetB},
+				startVM:     5,
+				nrVMs:       1,
+				firstV4Net:  baseNet,
+				maxNetworks: 2,
+				onFailure:   OnFailureContinue,
+			},
+			sequence: []step{
+				{
+					expected: []action{accessNetworkAction(networkName0)},
+				},
+				{
+					result:   accessNetworkAction(networkName0),
+					expected: []action{&addNetworkAction{networkName: "vmshed-1-extra", network: netA}},
+				},
+				{
+					result:   &addNetworkAction{networkName: "vmshed-1-extra", network: netA},
+					expected: []action{&performTestAction{run: &testRunNetA, ids: []int{5}, networkNames: []string{networkName0, "vmshed-1-extra"}}},
+				},
+				{
+					result:   &performTestAction{run: &testRunNetA, ids: []int{5}, networkNames: []string{networkName0, "vmshed-1-extra"}},
+					expected: []action{&removeNetworkAction{networkName: "vmshed-1-extra"}},
+				},
+				{
+					// the network may still exist, so it still counts
+					// towards the limit and netB cannot be added
+					result: &removeNetworkAction{networkName: "vmshed-1-extra", err: errors.New("remove failed")},
+				},
+			},
+		},
 	}
 
 	for _, test := range testCases {
@@ -376,11 +512,59 @@ func validateAction(t *testing.T, a, e action) {
 		if actual.network.Domain != expected.network.Domain {
 			t.Errorf("network domain name does not match, expected: '%s', actual: '%s'", expected.network.Domain, actual.network.Domain)
 		}
+	case *removeNetworkAction:
+		actual, ok := a.(*removeNetworkAction)
+		if !ok {
+			t.Fatalf("action type does not match, expected '%v', actual '%v'", reflect.TypeOf(expected), reflect.TypeOf(a))
+		}
+
+		if actual.networkName != expected.networkName {
+			t.Errorf("network name does not match, expected: '%s', actual: '%s'", expected.networkName, actual.networkName)
+		}
 	default:
 		t.Fatalf("unhandled expected action type: '%v'", reflect.TypeOf(e))
 	}
 }
 
 func accessNetworkAction(name string) action {
-	return &addNetworkAction{networkName: name, network: accessNetwork(false)}
+	return &addNetworkAction{networkName: name, network: accessNetwork(variant{})}
+}
+
+// TestAddFillRun checks that --fill takes the tests in turn, regardless of
+// how many runs each test has.
+func TestAddFillRun(t *testing.T) {
+	randomGenerator := rand.New(rand.NewSource(1))
+	vmSpec := &vmSpecification{VMs: []vm{{BaseImage: "b0"}, {BaseImage: "b1"}}}
+	manyConfig := &testConfig{testName: "many", vmSpec: vmSpec, repeats: 6}
+	oneConfig := &testConfig{testName: "one", vmSpec: vmSpec, repeats: 1}
+
+	suiteRun := testSuiteRun{
+		vmSpec:          vmSpec,
+		startVM:         5,
+		nrVMs:           1,
+		randomGenerator: randomGenerator,
+	}
+	for i := 0; i < 6; i++ {
+		suiteRun.testRuns = append(suiteRun.testRuns, newTestRun(randomGenerator, manyConfig, variant{Name: "default"}, vmSpec.VMs[:1], i, nil))
+	}
+	suiteRun.testRuns = append(suiteRun.testRuns, newTestRun(randomGenerator, oneConfig, variant{Name: "default"}, vmSpec.VMs[:1], 0, nil))
+
+	state := initializeState(&suiteRun)
+	for testID := range state.runStage {
+		state.runStage[testID] = runDone
+	}
+
+	added := map[string]int{}
+	for i := 0; i < 10; i++ {
+		if !addFillRun(&suiteRun, state) {
+			t.Fatalf("no run added in iteration %d", i)
+		}
+		run := suiteRun.testRuns[len(suiteRun.testRuns)-1]
+		added[run.testName]++
+		state.runStage[run.testID] = runDone
+	}
+
+	if added["many"] != 5 || added["one"] != 5 {
+		t.Errorf("expected 5 added runs of each test, got %v", added)
+	}
 }
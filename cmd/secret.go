@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const secretMask = "***"
+
+// secretMasker replaces secret values with "***" in everything vmshed writes.
+type secretMasker struct {
+	replacer *strings.Replacer // nil if there are no secrets
+}
+
+func newSecretMasker(values []string) *secretMasker {
+	unique := map[string]bool{}
+	for _, value := range values {
+		if value != "" {
+			unique[value] = true
+		}
+	}
+	if len(unique) == 0 {
+		return &secretMasker{}
+	}
+
+	// Replace longer values first, in case one secret contains another
+	sorted := make([]string, 0, len(unique))
+	for value := range unique {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		oldnew = append(oldnew, value, secretMask)
+	}
+	return &secretMasker{replacer: strings.NewReplacer(oldnew...)}
+}
+
+func (m *secretMasker) mask(s string) string {
+	if m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+func (m *secretMasker) maskBytes(b []byte) []byte {
+	if m.replacer == nil {
+		return b
+	}
+	return []byte(m.replacer.Replace(string(b)))
+}
+
+// secrets applies to all logs and files written by vmshed. It is set before
+// the test suite run starts.
+var secrets = newSecretMasker(nil)
+
+// secretValues returns the values to mask: the values given with
+// --secret-set and the values of the variables listed in secret_values.
+func secretValues(secretNames []string, secretOverrides []string, overrides []string, vmSpec *vmSpecification, testSpec *testSpecification) ([]string, error) {
+	values := []string{}
+	for _, override := range secretOverrides {
+		_, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("--secret-set %q: expected KEY=VALUE", override)
+		}
+		values = append(values, value)
+	}
+
+	for _, name := range secretNames {
+		found := false
+		add := func(variables map[string]string) {
+			if value, ok := variables[name]; ok {
+				values = append(values, value)
+				found = true
+			}
+		}
+
+		for _, v := range testSpec.Variants {
+			add(v.Variables)
+		}
+		for _, t := range testSpec.Tests {
+			add(t.Variables)
+		}
+		for _, v := range vmSpec.VMs {
+			add(v.Values)
+		}
+		for _, override := range overrides {
+			key, value, _ := strings.Cut(override, "=")
+			if key == "values."+name {
+				values = append(values, value)
+				found = true
+			}
+		}
+
+		if !found {
+			log.Warnf("Secret value %s is not set by any variant, test, VM or --set", name)
+		}
+	}
+	return values, nil
+}
+
+// maskingFormatter masks secrets in log entries.
+type maskingFormatter struct {
+	log.Formatter
+}
+
+func (f *maskingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	// Mask before formatting, so that quoting does not hide secrets
+	masked := *entry
+	masked.Message = secrets.mask(entry.Message)
+	masked.Data = make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if s := fmt.Sprint(value); secrets.mask(s) != s {
+			value = secrets.mask(s)
+		}
+		masked.Data[key] = value
+	}
+	return f.Formatter.Format(&masked)
+}
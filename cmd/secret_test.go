@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretMasker(t *testing.T) {
+	m := newSecretMasker([]string{"abc", "", "abcdef", "xyz"})
+	assert.Equal(t, "token=*** other=*** id=***", m.mask("token=abcdef other=abc id=xyz"))
+	assert.Equal(t, []byte("[***]"), m.maskBytes([]byte("[xyz]")))
+
+	empty := newSecretMasker(nil)
+	assert.Equal(t, "abc", empty.mask("abc"))
+}
+
+func TestSecretValues(t *testing.T) {
+	vmSpec := &vmSpecification{VMs: []vm{{BaseImage: "b", Values: map[string]string{"REPO_KEY": "vm-secret"}}}}
+	testSpec := &testSpecification{
+		Variants: []variant{{Name: "v", Variables: map[string]string{"TOKEN": "variant-secret", "PLAIN": "visible"}}},
+		Tests:    map[string]test{"t": {Variables: map[string]string{"TOKEN": "test-secret"}}},
+	}
+
+	values, err := secretValues(
+		[]string{"TOKEN", "REPO_KEY"},
+		[]string{"values.PASSWORD=flag-secret"},
+		[]string{"values.TOKEN=set-secret", "values.PLAIN=visible"},
+		vmSpec, testSpec)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"flag-secret", "variant-secret", "test-secret", "vm-secret", "set-secret"}, values)
+
+	_, err = secretValues(nil, []string{"no-value"}, nil, vmSpec, testSpec)
+	assert.Error(t, err)
+}
+
+func TestMaskingFormatter(t *testing.T) {
+	saved := secrets
+	t.Cleanup(func() { secrets = saved })
+	secrets = newSecretMasker([]string{`se"cret`})
+
+	var out bytes.Buffer
+	logger := log.New()
+	logger.Out = &out
+	logger.Formatter = &maskingFormatter{&log.TextFormatter{DisableTimestamp: true}}
+
+	logger.WithField("arg", `values.TOKEN=se"cret`).Infof("EXECUTING: [virter --set values.TOKEN=%s]", `se"cret`)
+	assert.NotContains(t, out.String(), "cret")
+	assert.Contains(t, out.String(), "values.TOKEN=***")
+}
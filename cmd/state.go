@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const stateFileName = "state.json"
+
+// savedState is the content of the state file which allows a test suite run
+// to be resumed with --resume.
+type savedState struct {
+	StartTime    time.Time        `json:"start_time"`
+	Runs         []savedRun       `json:"runs"`
+	Networks     []string         `json:"networks"`
+	Provisioning []savedProvision `json:"provisioning,omitempty"`
+	// Instance and VM IDs after registration
+	Instance string `json:"instance,omitempty"`
+	StartVM  int    `json:"start_vm,omitempty"`
+	NrVMs    int    `json:"nr_vms,omitempty"`
+}
+
+// savedProvision is an image that is being provisioned. Virter names the VM
+// used for provisioning after the image.
+type savedProvision struct {
+	VM      string `json:"vm"`
+	Image   string `json:"image"`
+	ID      int    `json:"id"`
+	Network string `json:"network"`
+}
+
+type savedRun struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Variant    string     `json:"variant"`
+	VMs        []string   `json:"vms"`
+	Priority   uint64     `json:"priority"`
+	Stage      runStage   `json:"stage"`
+	IDs        []int      `json:"ids,omitempty"`
+	Networks   []string   `json:"networks,omitempty"`
+	Status     TestStatus `json:"status,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	DurationNS int64      `json:"duration_ns,omitempty"`
+	Start      time.Time  `json:"start,omitzero"`
+}
+
+// resumable returns whether the run has a result that should be kept when
+// resuming.
+func (r *savedRun) resumable() bool {
+	return r.Stage == runDone && r.Status != StatusCanceled
+}
+
+func stateFilePath(outDir string) string {
+	return filepath.Join(outDir, stateFileName)
+}
+
+// saveState atomically writes the state of the test suite run to the state
+// file in the output directory.
+func saveState(suiteRun *testSuiteRun, state *suiteState) error {
+	saved := savedState{
+		StartTime: suiteRun.startTime,
+		Runs:      make([]savedRun, 0, len(suiteRun.testRuns)),
+		Networks:  make([]string, 0, len(state.networks)),
+		Instance:  suiteRun.instance,
+		StartVM:   suiteRun.startVM,
+		NrVMs:     suiteRun.nrVMs,
+	}
+
+	for networkName, ns := range state.networks {
+		if ns.stage == networkNoSubnet {
+			continue
+		}
+		saved.Networks = append(saved.Networks, networkName)
+	}
+	sort.Strings(saved.Networks)
+
+	for i := range suiteRun.vmSpec.VMs {
+		v := &suiteRun.vmSpec.VMs[i]
+		resources, ok := state.provisionResources[v.ID()]
+		if !ok {
+			continue
+		}
+		saved.Provisioning = append(saved.Provisioning, savedProvision{
+			VM:      v.ID(),
+			Image:   suiteRun.vmSpec.ImageName(v),
+			ID:      resources.id,
+			Network: resources.networkName,
+		})
+	}
+
+	for _, run := range suiteRun.testRuns {
+		resources := state.runResources[run.testID]
+		s := savedRun{
+			ID:       run.testID,
+			Name:     run.testName,
+			Variant:  run.variant.Name,
+			VMs:      baseImageNames(run.vms),
+			Priority: run.priority,
+			Stage:    state.runStage[run.testID],
+			IDs:      resources.ids,
+			Networks: resources.networkNames,
+		}
+
+		if res, ok := state.runResults[run.testID]; ok && s.Stage == runDone {
+			s.Status = res.status
+			s.DurationNS = res.execTime.Nanoseconds()
+			s.Start = res.startTime
+			s.Note = secrets.mask(res.note)
+			if res.err != nil {
+				s.Error = secrets.mask(res.err.Error())
+			}
+		}
+
+		saved.Runs = append(saved.Runs, s)
+	}
+
+	data, err := json.MarshalIndent(&saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	return writeFileAtomic(stateFilePath(suiteRun.outDir), data, 0644)
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it, so that
+// the file either has the old or the new content, even after a crash. The file
+// gets the given permissions regardless of the umask.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+func loadState(outDir string) (*savedState, error) {
+	data, err := os.ReadFile(stateFilePath(outDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var saved savedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	return &saved, nil
+}
+
+// restoreTestRuns recreates the test runs from the state file. The planned
+// runs are used to find the configuration of each test.
+func restoreTestRuns(saved *savedState, planned []testRun, vms []vm, variants []variant) ([]testRun, map[string]testResult, error) {
+	configs := map[string]*testConfig{}
+	for _, run := range planned {
+		configs[run.testName] = run.config
+	}
+
+	vmsByID := map[string]vm{}
+	for _, v := range vms {
+		vmsByID[v.ID()] = v
+	}
+
+	variantsByName := map[string]variant{}
+	for _, v := range variants {
+		variantsByName[v.Name] = v
+	}
+
+	testRuns := []testRun{}
+	results := map[string]testResult{}
+	for _, s := range saved.Runs {
+		config, ok := configs[s.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("test %s from state file is not selected", s.Name)
+		}
+
+		runVariant, ok := variantsByName[s.Variant]
+		if !ok {
+			return nil, nil, fmt.Errorf("variant %s from state file is not selected", s.Variant)
+		}
+
+		runVMs := make([]vm, 0, len(s.VMs))
+		for _, id := range s.VMs {
+			v, ok := vmsByID[id]
+			if !ok {
+				return nil, nil, fmt.Errorf("VM %s from state file is not selected", id)
+			}
+			runVMs = append(runVMs, v)
+		}
+
+		testRuns = append(testRuns, testRun{
+			testName:      s.Name,
+			testID:        s.ID,
+			priority:      s.Priority,
+			outDir:        filepath.Join(config.testLogDir, s.ID),
+			vms:           runVMs,
+			networks:      config.networks,
+			networkEvents: config.test.NetworkEvents,
+			variant:       runVariant,
+			variables:     config.test.Variables,
+			maxParallel:   config.maxParallel,
+			disabled:      config.test.Disabled,
+			config:        config,
+		})
+
+		if s.resumable() {
+			res := testResult{
+				status:    s.Status,
+				execTime:  time.Duration(s.DurationNS),
+				startTime: s.Start,
+				note:      s.Note,
+			}
+			if s.Error != "" {
+				res.err = errors.New(s.Error)
+			}
+			results[s.ID] = res
+		}
+	}
+
+	return testRuns, results, nil
+}
+
+// cleanupInterruptedRuns removes the VMs of runs that were executing, the VMs
+// of images that were being provisioned and all networks of the interrupted
+// test suite run.
+func cleanupInterruptedRuns(outDir string, instance string, saved *savedState) {
+	for _, s := range saved.Runs {
+		if s.Stage != runExec {
+			continue
+		}
+
+		log.Infof("RESUME: Remove VMs of interrupted run %s", s.ID)
+		logDir := filepath.Join(outDir, "log", s.ID)
+		for _, id := range s.IDs {
+			vm := vmInstance{instance: instance, nr: id, networkNames: s.Networks}
+			if err := removeVM(log.StandardLogger(), logDir, vm); err != nil {
+				log.Errorf("ERROR: Could not remove VM %s: %v", vm.vmName(), err)
+				dumpStderr(log.StandardLogger(), err)
+			}
+		}
+	}
+
+	for _, p := range saved.Provisioning {
+		log.Infof("RESUME: Remove VM of interrupted provisioning of %s", p.VM)
+		if err := removeVMByName(log.StandardLogger(), filepath.Join(outDir, "provision-log"), p.Image, p.Network); err != nil {
+			log.Errorf("ERROR: Could not remove VM %s: %v", p.Image, err)
+			dumpStderr(log.StandardLogger(), err)
+		}
+	}
+
+	for _, networkName := range saved.Networks {
+		// errors are logged by removeNetwork
+		removeNetwork(outDir, networkName)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"maps"
 	"os"
 	"os/exec"
@@ -22,17 +23,26 @@ type test struct {
 	Variants         []string          `toml:"variants"`         // only run on given variants, if empty all
 	Networks         []virterNet       `toml:"networks"`         // Extra NIC to add to the VMs
 	Variables        map[string]string `toml:"variables"`        // overwrite variables from variants
+	MaxParallel      *int              `toml:"max_parallel"`     // maximum number of concurrent runs of this test, 0 means unlimited, unset means the suite default
+	Combinations     string            `toml:"combinations"`     // how to combine base images for multiple VMs: random, pairwise or all
+	NetworkEvents    []networkEvent    `toml:"network_events"`   // changes of the network conditions while the test runs
+	Disabled         string            `toml:"disabled"`         // reason for not running this test, if empty the test is run
 }
 
 type testRun struct {
-	testName  string
-	testID    string
-	priority  uint64
-	outDir    string
-	vms       []vm
-	networks  []virterNet
-	variant   variant
-	variables map[string]string
+	testName      string
+	testID        string
+	priority      uint64
+	outDir        string
+	vms           []vm
+	networks      []virterNet
+	networkEvents []networkEvent
+	variant       variant
+	variables     map[string]string
+	maxParallel   int
+	disabled      string // reason for not running this run
+	repeat        bool   // added by --until-failure or --fill
+	config        *testConfig
 }
 
 type TestStatus string
@@ -44,6 +54,8 @@ const (
 	StatusFailedTimeout TestStatus = "FAILED(TO)"
 	StatusFailed        TestStatus = "FAILED"
 	StatusError         TestStatus = "ERROR" // Error running test
+	StatusXFail         TestStatus = "XFAIL" // Failed as expected
+	StatusXPass         TestStatus = "XPASS" // Succeeded although expected to fail
 )
 
 type TestResulter interface {
@@ -54,11 +66,17 @@ type TestResulter interface {
 // collect information about individual test runs
 // the interface is similar to the log package (which it also uses)
 type testResult struct {
-	log      bytes.Buffer // log messages of the framework (starting test, timing information,...)
-	testLog  bytes.Buffer // output of the test itself ('virter vm exec' output)
-	execTime time.Duration
-	err      error
-	status   TestStatus
+	log        bytes.Buffer // log messages of the framework (starting test, timing information,...)
+	testLog    bytes.Buffer // output of the test itself ('virter vm exec' output)
+	testLogErr error        // error writing test.log
+	startTime  time.Time
+	execTime   time.Duration
+	err        error
+	status     TestStatus
+	note       string // explains XFAIL, XPASS and disabled runs
+	// The run was canceled by a signal or a control command, as opposed to
+	// the suite stopping after a failure
+	userCanceled bool
 }
 
 func (r testResult) ExecTime() time.Duration {
@@ -69,6 +87,17 @@ func (r testResult) Err() error {
 	return r.err
 }
 
+// disabledResult is the result of a run of a disabled test.
+func disabledResult(run *testRun) testResult {
+	return testResult{status: StatusSkipped, note: "disabled: " + run.disabled}
+}
+
+// notStartedResult is the result of a run that was not started because of a
+// control command.
+func notStartedResult(reason string) testResult {
+	return testResult{status: StatusSkipped, note: reason}
+}
+
 func (r testResult) String() string {
 	return string(r.status)
 }
@@ -111,7 +140,15 @@ func performTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, ids
 		}
 		userName = v.UserName
 
+		var nics []string
+		for j, network := range run.networks {
+			if network.attached(i) {
+				nics = append(nics, nicArgument(networkNames[j+1], network, i))
+			}
+		}
+
 		instance := vmInstance{
+			instance:     suiteRun.instance,
 			ImageName:    suiteRun.vmSpec.ImageName(&v),
 			nr:           ids[i],
 			memory:       memory,
@@ -119,13 +156,18 @@ func performTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, ids
 			bootCap:      bootCap,
 			disks:        disks,
 			networkNames: networkNames,
+			nics:         nics,
 			UserName:     userName,
 		}
 		vms = append(vms, instance)
 	}
 
-	testRes := execTest(ctx, suiteRun, run, networkNames[0], vms)
+	return execTest(ctx, suiteRun, run, networkNames[0], vms)
+}
 
+// writeReport writes report.log and the JUnit XML file of a test run. Errors
+// writing the logs replace the error of the result.
+func writeReport(suiteRun *testSuiteRun, run *testRun, testRes *testResult) string {
 	var report bytes.Buffer
 
 	fmt.Fprintln(&report, "|===================================================================================================")
@@ -147,9 +189,9 @@ func performTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, ids
 	}
 
 	testLog := testRes.testLog.Bytes()
-	if err := os.WriteFile(filepath.Join(run.outDir, "test.log"), testLog, 0644); err != nil {
+	if testRes.testLogErr != nil {
 		fmt.Fprintf(&report, "| FAILED to write log; suppressing original error: %v\n", testRes.err)
-		testRes.err = err
+		testRes.err = testRes.testLogErr
 	}
 
 	resultsDir := filepath.Join(suiteRun.outDir, "test-results")
@@ -159,31 +201,61 @@ func performTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, ids
 	}
 	fmt.Fprintln(&report, "|===================================================================================================")
 
-	if err := os.WriteFile(filepath.Join(run.outDir, "report.log"), report.Bytes(), 0644); err != nil {
+	reportText := secrets.mask(report.String())
+	if err := os.WriteFile(filepath.Join(run.outDir, "report.log"), []byte(reportText), 0644); err != nil {
 		log.Errorf("Failed to write report; suppressing original error: %v\n", testRes.err)
 		testRes.err = err
 	}
 
-	return report.String(), testRes
+	return reportText
 }
 
-func execTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, accessNetwork string, testnodes []vmInstance) testResult {
-	res := testResult{}
+func execTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, accessNetwork string, testnodes []vmInstance) (report string, res testResult) {
 	logger := TestLogger(run.testID, &res.log)
 
 	logger.Debugf("EXECUTING: %s Nodes(%+v)", run.testID, testnodes)
 
+	// Write the test output to test.log as it arrives, so that it can be
+	// watched and is kept if vmshed crashes
+	testLogFile, err := createTestLog(run)
+	if err != nil {
+		res.testLogErr = err
+	} else {
+		defer testLogFile.Close()
+	}
+
 	// Start VMs
 	start := time.Now()
-	err := startVMs(ctx, logger, run, testnodes...)
+	res.startTime = start
+	err = startVMs(ctx, logger, run, testnodes...)
+	// Write the logs once the VMs have been removed, so that they include
+	// the output of the removal
+	defer func() {
+		report = writeReport(suiteRun, run, &res)
+	}()
 	defer shutdownVMs(logger, run.outDir, &res, suiteRun, testnodes...)
+	defer func() {
+		if pausesOnFailure(suiteRun, &res) {
+			// Write the logs so far, so that they can be inspected
+			// while the VMs of the failed run are kept
+			writeReport(suiteRun, run, &res)
+			pauseOnFailure(ctx, suiteRun, run, &res, testnodes)
+		}
+	}()
 	if err != nil {
 		res.status = StatusError
 		res.err = fmt.Errorf("failed to start VMs: %w", err)
-		return res
+		return
 	}
 	logger.Debugf("EXECUTIONTIME: Starting VMs: %v", time.Since(start))
 
+	emulation, err := startNetworkEmulation(ctx, logger, run, testnodes)
+	if err != nil {
+		res.status = StatusError
+		res.err = fmt.Errorf("failed to configure network emulation: %w", err)
+		return
+	}
+
 	testNameEnv := fmt.Sprintf("env.TEST_NAME=%s", run.testName)
 	outDirValue := fmt.Sprintf("values.OutDir=%s", run.outDir)
 
@@ -213,7 +285,18 @@ func execTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, accessN
 
 	cmd := exec.Command(argv[0], argv[1:]...)
 	cmd.Env = virterEnv(accessNetwork)
-	cmd.Stderr = &res.testLog
+	testOutput := []io.Writer{&res.testLog}
+	if testLogFile != nil {
+		testOutput = append(testOutput, &errorRecordingWriter{w: testLogFile, err: &res.testLogErr})
+	}
+	var console *lineWriter
+	if following(suiteRun, run) {
+		console = &lineWriter{out: log.StandardLogger().Out, prefix: fmt.Sprintf("[%s] ", run.testID), terminate: true}
+		testOutput = append(testOutput, console)
+	}
+	// Pass on complete lines, so that secrets can be masked
+	output := &lineWriter{out: io.MultiWriter(testOutput...)}
+	cmd.Stderr = output
 
 	testCtx, cancel := context.WithTimeout(ctx, time.Duration(suiteRun.testSpec.TestTimeout))
 	defer cancel()
@@ -221,9 +304,14 @@ func execTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, accessN
 	logger.Debugf("EXECUTING TEST: %s", argv)
 	start = time.Now()
 	res.err = cmdRunTerm(testCtx, logger, cmd)
+	output.Close()
+	if console != nil {
+		console.Close()
+	}
 	timeout := testCtx.Err() != nil
 	res.execTime = time.Since(start)
 	logger.Debugf("EXECUTIONTIME: Running test %s: %v", run.testID, res.execTime)
+	eventErr := emulation.stop()
 
 	if exitErr, ok := res.err.(*exec.ExitError); ok {
 		exitErr.Stderr = res.testLog.Bytes()
@@ -247,16 +335,21 @@ func execTest(ctx context.Context, suiteRun *testSuiteRun, run *testRun, accessN
 	if ctx.Err() != nil {
 		res.status = StatusCanceled
 		res.err = fmt.Errorf("canceled")
+		res.userCanceled = canceledByUser(ctx)
 	} else if timeout {
 		res.status = StatusFailedTimeout
 		res.err = fmt.Errorf("timeout: %w", res.err)
 	} else if res.err != nil {
 		res.status = StatusFailed
+	} else if eventErr != nil {
+		res.status = StatusError
+		res.err = fmt.Errorf("network event failed: %w", eventErr)
 	} else {
 		res.status = StatusSuccess
 	}
 
-	return res
+	applyExpectedFailure(logger, suiteRun.expectedFailures, run, &res)
+	return
 }
 
 func copyDir(logger log.FieldLogger, vm vmInstance, logDir string, srcDir string, hostDir string) error {
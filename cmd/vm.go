@@ -35,6 +35,7 @@ func (v *vm) ID() string {
 }
 
 type vmInstance struct {
+	instance     string
 	ImageName    string
 	nr           int
 	memory       string
@@ -42,11 +43,12 @@ type vmInstance struct {
 	bootCap      string
 	disks        []string
 	networkNames []string
+	nics         []string // values for --nic, one for each attached extra network
 	UserName     string
 }
 
 func (vm vmInstance) vmName() string {
-	return fmt.Sprintf("lbtest-vm-%d", vm.nr)
+	return fmt.Sprintf("%slbtest-vm-%d", instancePrefix(vm.instance), vm.nr)
 }
 
 func testIDString(test string, vmCount int, variantName string, testIndex int) string {
@@ -157,13 +159,16 @@ func provisionImage(ctx context.Context, suiteRun *testSuiteRun, nr int, v *vm,
 	return err
 }
 
-func removeImages(outDir string, vmSpec *vmSpecification) {
+// removeImages removes the provisioned images. It returns the errors for the
+// images that could not be removed.
+func removeImages(outDir string, vmSpec *vmSpecification) []error {
 	if vmSpec.ProvisionFile == "" {
-		return
+		return nil
 	}
 
 	provisionOutDir := filepath.Join(outDir, "provision-log")
 
+	var errs []error
 	for _, v := range vmSpec.VMs {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
@@ -177,9 +182,11 @@ func removeImages(outDir string, vmSpec *vmSpecification) {
 		if err := cmdStderrTerm(ctx, log.StandardLogger(), stderrPath, "", cmd); err != nil {
 			log.Errorf("ERROR: Could not remove image %s %v", newImageName, err)
 			dumpStderr(log.StandardLogger(), err)
+			errs = append(errs, fmt.Errorf("remove image %s: %w", newImageName, err))
 			// do not return, keep going...
 		}
 	}
+	return errs
 }
 
 func startVMs(ctx context.Context, logger *log.Logger, run *testRun, testnodes ...vmInstance) error {
@@ -230,8 +237,8 @@ func runVM(ctx context.Context, logger *log.Logger, run *testRun, vm vmInstance)
 	for _, disks := range vm.disks {
 		argv = append(argv, "--disk", disks)
 	}
-	for _, networkName := range vm.networkNames[1:] {
-		argv = append(argv, "--nic", fmt.Sprintf("type=network,source=%s", networkName))
+	for _, nic := range vm.nics {
+		argv = append(argv, "--nic", nic)
 	}
 	argv = append(argv, "--wait-ssh", vm.ImageName)
 
@@ -259,20 +266,13 @@ func shutdownVMs(logger *log.Logger, outDir string, res *testResult, suiteRun *t
 	vmNames := make([]string, 0, len(testnodes))
 
 	for _, vm := range testnodes {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
 		vmName := vm.vmName()
 		vmNames = append(vmNames, vmName)
 
-		argv := []string{"virter", "vm", "rm", vmName}
-		stderrPath := filepath.Join(outDir, fmt.Sprintf("vm_rm_%s.log", vmName))
-		logger.Debugf("EXECUTING: %s", argv)
-		cmd := exec.Command(argv[0], argv[1:]...)
-		cmd.Env = virterEnv(vm.networkNames[0])
-		if err := cmdStderrTerm(ctx, logger, stderrPath, "", cmd); err != nil {
+		if err := removeVM(logger, outDir, vm); err != nil {
 			logger.Errorf("ERROR: Could not stop VM %s: %v", vmName, err)
 			dumpStderr(logger, err)
+			suiteRun.cleanupFailures.add(fmt.Errorf("remove VM %s: %w", vmName, err))
 			// do not return, keep going...
 		}
 	}
@@ -281,6 +281,30 @@ func shutdownVMs(logger *log.Logger, outDir string, res *testResult, suiteRun *t
 	logger.Debugf("FINISH: VMs removed: %v", strings.Join(vmNames, " "))
 }
 
+func removeVM(logger *log.Logger, outDir string, vm vmInstance) error {
+	accessNetwork := ""
+	if len(vm.networkNames) > 0 {
+		accessNetwork = vm.networkNames[0]
+	}
+	return removeVMByName(logger, outDir, vm.vmName(), accessNetwork)
+}
+
+// removeVMByName removes a VM. The access network is used to remove the DHCP
+// entry of the VM, if given.
+func removeVMByName(logger *log.Logger, outDir string, vmName string, accessNetwork string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	argv := []string{"virter", "vm", "rm", vmName}
+	stderrPath := filepath.Join(outDir, fmt.Sprintf("vm_rm_%s.log", vmName))
+	logger.Debugf("EXECUTING: %s", argv)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if accessNetwork != "" {
+		cmd.Env = virterEnv(accessNetwork)
+	}
+	return cmdStderrTerm(ctx, logger, stderrPath, "", cmd)
+}
+
 func virterEnv(networkName string) []string {
 	return append(os.Environ(), fmt.Sprintf("VIRTER_LIBVIRT_NETWORK=%s", networkName), "VIRTER_LIBVIRT_STATIC_DHCP=true")
 }
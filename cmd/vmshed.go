@@ -8,7 +8,6 @@ import (
 	"net"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
@@ -19,7 +18,6 @@ import (
 	"github.com/BurntSushi/toml"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"golang.org/x/sys/unix"
 
 	"github.com/LINBIT/vmshed/cmd/config"
 )
@@ -50,6 +48,7 @@ type vmSpecification struct {
 	ProvisionMemory  string   `toml:"provision_memory"`
 	ProvisionCPUs    uint     `toml:"provision_cpus"`
 	VMs              []vm     `toml:"vms"`
+	instance         string
 }
 
 func (s *vmSpecification) ImageName(v *vm) string {
@@ -57,7 +56,7 @@ func (s *vmSpecification) ImageName(v *vm) string {
 		// No provisioning, use base image directly
 		return v.ID()
 	}
-	return fmt.Sprintf("%s-%s", v.ID(), s.Name)
+	return fmt.Sprintf("%s%s-%s", instancePrefix(s.instance), v.ID(), s.Name)
 }
 
 type testSpecification struct {
@@ -67,20 +66,40 @@ type testSpecification struct {
 	Networks      []virterNet     `toml:"networks"` // Extra NIC to add to the VMs for all tests
 	Artifacts     []string        `toml:"artifacts"`
 	Variants      []variant       `toml:"variants"`
+	MaxParallel   int             `toml:"max_parallel"` // Default for tests.<name>.max_parallel
+
+	ExpectedFailures []expectedFailure `toml:"expected_failures"`
+	SecretValues     []string          `toml:"secret_values"` // variables whose values are masked in logs and reports
 }
 
 type variant struct {
 	Name      string            `toml:"name"`
 	Variables map[string]string `toml:"variables"`
+	IPv4      *bool             `toml:"ipv4"` // defaults to true
 	IPv6      bool              `toml:"ipv6"`
 	VMTags    []string          `toml:"vm_tags"`
 }
 
 type virterNet struct {
 	ForwardMode string `toml:"forward"`
+	IPv4        *bool  `toml:"ipv4"` // defaults to true
 	IPv6        bool   `toml:"ipv6"`
 	DHCP        bool   `toml:"dhcp"`
 	Domain      string `toml:"domain"`
+	IPv4Prefix  int    `toml:"ipv4_prefix"` // 0 means the size of --first-subnet
+	IPv6Prefix  int    `toml:"ipv6_prefix"` // 0 means the size of --first-v6-subnet
+
+	// Network emulation applied to the VMs on this network
+	Latency   duration `toml:"latency"`
+	Jitter    duration `toml:"jitter"`
+	Loss      float64  `toml:"loss"`      // percent
+	Reorder   float64  `toml:"reorder"`   // percent
+	Bandwidth string   `toml:"bandwidth"` // tc rate, e.g. "100mbit"
+
+	// Attachment of the VMs to this network
+	VMs  []int    `toml:"vms"`  // indexes of the attached VMs, defaults to all
+	MACs []string `toml:"macs"` // MAC address of the VM with the corresponding index
+	MTU  int      `toml:"mtu"`
 }
 
 type FailurePolicy string
@@ -92,20 +111,40 @@ const (
 )
 
 type testSuiteRun struct {
-	vmSpec            *vmSpecification
-	testSpec          *testSpecification
-	overrides         []string
-	outDir            string
-	testRuns          []testRun
-	startVM           int
-	nrVMs             int
-	firstV4Net        *net.IPNet
-	firstV6Net        *net.IPNet
+	vmSpec     *vmSpecification
+	testSpec   *testSpecification
+	overrides  []string
+	outDir     string
+	testRuns   []testRun
+	startVM    int
+	nrVMs      int
+	firstV4Net *net.IPNet
+	firstV6Net *net.IPNet
+	// First subnets after the block reserved for this instance, nil if
+	// unlimited
+	endV4Net          *net.IPNet
+	endV6Net          *net.IPNet
 	onFailure         FailurePolicy
 	printErrorDetails bool
 	logFormatVirter   string
 	pullImageTemplate *template.Template
 	timeoutSoft       time.Duration
+	untilFailure      bool
+	fill              bool
+	maxNetworks       int
+	randomGenerator   *rand.Rand
+	seed              int64
+	startTime         time.Time
+	resumedResults    map[string]testResult
+	instance          string
+	expectedFailures  []expectedFailure
+	exitCodes         map[OutcomeClass]int
+	successThreshold  float64       // percent
+	pauses            *pauseControl // nil unless --pause-on-failure is given
+	controlCommands   <-chan controlMessage
+	dumps             *stateDumper
+	cleanupFailures   *cleanupReport
+	follow            []string // test names or IDs whose output is shown on the console
 }
 
 func (f *FailurePolicy) String() string {
@@ -126,13 +165,40 @@ func (f *FailurePolicy) Type() string {
 	return "FailurePolicy"
 }
 
+type VMSelection string
+
+const (
+	VMSelectionRandom   VMSelection = "random"
+	VMSelectionBalanced VMSelection = "balanced"
+)
+
+func (s *VMSelection) String() string {
+	return string(*s)
+}
+
+func (s *VMSelection) Set(v string) error {
+	switch v {
+	case "random", "balanced":
+		*s = VMSelection(v)
+		return nil
+	default:
+		return errors.New("should be one out of \"random\" \"balanced\"")
+	}
+}
+
+func (s *VMSelection) Type() string {
+	return "VMSelection"
+}
+
 type testConfig struct {
-	testLogDir string
-	vmSpec     *vmSpecification
-	testName   string
-	test       test
-	repeats    int
-	networks   []virterNet // includes networks configured for all tests as well as for this test specifically
+	testLogDir  string
+	vmSpec      *vmSpecification
+	testName    string
+	test        test
+	repeats     int
+	networks    []virterNet // includes networks configured for all tests as well as for this test specifically
+	maxParallel int
+	vmUsage     map[string]int // shared between all tests, nil for purely random VM selection
 }
 
 type TemplateFlag struct {
@@ -163,7 +229,7 @@ func (t *TemplateFlag) Type() string {
 
 // Execute runs vmshed
 func Execute() {
-	log.SetFormatter(VmshedStandardLogFormatter())
+	log.SetFormatter(&maskingFormatter{VmshedStandardLogFormatter()})
 
 	if err := rootCommand().Execute(); err != nil {
 		log.Fatal(err)
@@ -194,6 +260,23 @@ func rootCommand() *cobra.Command {
 	var firstv6Subnet string
 	var pullImageTemplate TemplateFlag
 	var timeoutSoft time.Duration
+	var untilFailure bool
+	var maxDuration time.Duration
+	var fill bool
+	var maxNetworks int
+	var vmSelection VMSelection = VMSelectionRandom
+	var vmSelectionHistory []string
+	var resume bool
+	var instance string
+	var registryDir string
+	var quarantinePath string
+	var exitCodeOverrides map[string]int
+	var successThreshold float64
+	var pauseOnFailure bool
+	var controlSocket string
+	var terminationGracePeriod time.Duration
+	var follow []string
+	var secretOverrides []string
 
 	rootCmd := &cobra.Command{
 		Use:   "vmshed",
@@ -223,6 +306,28 @@ current user.`,
 			if nrVMs <= 0 {
 				log.Fatal("--nvms has to be positive")
 			}
+			if maxDuration > 0 && !untilFailure {
+				log.Fatal("--max-duration requires --until-failure")
+			}
+			if fill && timeoutSoft <= 0 {
+				log.Fatal("--fill requires --timeout-soft")
+			}
+			if maxNetworks < 0 {
+				log.Fatal("--max-networks must not be negative")
+			}
+			if successThreshold < 0 || successThreshold > 100 {
+				log.Fatal("--success-threshold must be between 0 and 100")
+			}
+			if terminationGracePeriod < 0 {
+				log.Fatal("--termination-grace-period must not be negative")
+			}
+			exitCodes, err := parseExitCodes(exitCodeOverrides)
+			if err != nil {
+				log.Fatalf("--exit-code: %v", err)
+			}
+			if err := validateInstanceName(instance); err != nil {
+				log.Fatal(err)
+			}
 
 			if randomSeed == 0 {
 				randomSeed = time.Now().UTC().UnixNano()
@@ -231,7 +336,7 @@ current user.`,
 			log.Infof("Using random seed: %d", randomSeed)
 			randomGenerator := rand.New(rand.NewSource(randomSeed))
 
-			err := os.MkdirAll(outDir, 0755)
+			err = os.MkdirAll(outDir, 0755)
 			if err != nil {
 				log.Fatalf("could not mkdir %s: %v", outDir, err)
 			}
@@ -243,6 +348,7 @@ current user.`,
 			vmSpec.ProvisionFile = joinIfRel(filepath.Dir(vmSpecPath), vmSpec.ProvisionFile)
 			vmSpec.ProvisionTimeout = durationDefault(vmSpec.ProvisionTimeout, 3*time.Minute)
 			vmSpec.VMs = filterVMs(vmSpec.VMs, baseImages, excludeBaseImages)
+			vmSpec.instance = instance
 
 			var testSpec testSpecification
 			if _, err := toml.DecodeFile(testSpecPath, &testSpec); err != nil {
@@ -254,7 +360,47 @@ current user.`,
 			testSpec.TestSuiteFile = joinIfRel(filepath.Dir(testSpecPath), testSpec.TestSuiteFile)
 			testSpec.TestTimeout = durationDefault(testSpec.TestTimeout, 5*time.Minute)
 
-			suiteRun, err := createTestSuiteRun(randomGenerator, vmSpec, testSpec, toRun, outDir, repeats, variantsToRun)
+			secretVals, err := secretValues(testSpec.SecretValues, secretOverrides, provisionOverrides, &vmSpec, &testSpec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			secrets = newSecretMasker(secretVals)
+
+			expectedFailures := testSpec.ExpectedFailures
+			if quarantinePath != "" {
+				quarantined, err := loadQuarantineFile(quarantinePath)
+				if err != nil {
+					log.Fatal(err)
+				}
+				expectedFailures = append(expectedFailures, quarantined...)
+			}
+			expectedFailures, err = activeExpectedFailures(expectedFailures, time.Now())
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var vmUsage map[string]int
+			if vmSelection == VMSelectionBalanced {
+				vmUsage, err = loadVMUsage(vmSelectionHistory)
+				if err != nil {
+					log.Fatal(err)
+				}
+			} else if len(vmSelectionHistory) > 0 {
+				log.Fatal("--vm-selection-history requires '--vm-selection balanced'")
+			}
+
+			var resumeState *savedState
+			if resume {
+				resumeState, err = loadState(outDir)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if resumeState.StartVM > 0 && resumeState.Instance != instance {
+					log.Fatalf("state file is from %s, resume with the same --instance", instanceDescription(resumeState.Instance))
+				}
+			}
+
+			suiteRun, err := createTestSuiteRun(randomGenerator, vmSpec, testSpec, toRun, outDir, repeats, variantsToRun, vmUsage, resumeState)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -269,7 +415,7 @@ current user.`,
 				log.Fatal(err)
 			}
 
-			suiteRun.overrides = provisionOverrides
+			suiteRun.overrides = append(provisionOverrides, secretOverrides...)
 			suiteRun.startVM = startVM
 			suiteRun.nrVMs = nrVMs
 			suiteRun.onFailure = onFailure
@@ -279,22 +425,95 @@ current user.`,
 			suiteRun.logFormatVirter = logFormatVirter
 			suiteRun.pullImageTemplate = pullImageTemplate.Template
 			suiteRun.timeoutSoft = timeoutSoft
+			suiteRun.untilFailure = untilFailure
+			suiteRun.fill = fill
+			suiteRun.maxNetworks = maxNetworks
+			if err := validateMaxNetworks(&suiteRun, maxNetworks); err != nil {
+				log.Fatal(err)
+			}
+			suiteRun.instance = instance
+			suiteRun.seed = randomSeed
+			suiteRun.expectedFailures = expectedFailures
+			suiteRun.exitCodes = exitCodes
+			suiteRun.successThreshold = successThreshold
+			suiteRun.follow = follow
+			warnUnknownFollow(&suiteRun)
+
+			if untilFailure {
+				if onFailure == OnFailureContinue {
+					suiteRun.onFailure = OnFailureTerminate
+				}
+				if maxDuration > 0 && (timeoutSoft == 0 || maxDuration < timeoutSoft) {
+					suiteRun.timeoutSoft = maxDuration
+				}
+			}
+
+			suiteRun.dumps = newStateDumper()
+			go suiteRun.dumps.watchSignals(&suiteRun)
+
+			var control *controlServer
+			if controlSocket != "" {
+				control, err = startControlServer(controlSocket)
+				if err != nil {
+					log.Fatal(err)
+				}
+				suiteRun.controlCommands = control.commands
+			}
+
+			// Without --instance or --registry-dir, the VM IDs and
+			// subnets are given by --startvm and --first-subnet
+			unregister := func() {}
+			if instance != "" || cmd.Flags().Changed("registry-dir") {
+				unregister, err = registerInstance(registryDir, &suiteRun)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
 
-			ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGINT, unix.SIGTERM)
+			termination = newTerminationControl(terminationGracePeriod)
+			suiteRun.cleanupFailures = &cleanupReport{}
+
+			ctx, cancel := interruptContext(context.Background())
 			defer cancel()
+
+			if pauseOnFailure {
+				suiteRun.pauses = newPauseControl()
+				go suiteRun.pauses.watchInput(os.Stdin)
+				go suiteRun.pauses.watchSignals(ctx)
+			}
+
 			start := time.Now()
 
+			suiteRun.startTime = start
+			if resumeState != nil {
+				suiteRun.startTime = resumeState.StartTime
+				cleanupInterruptedRuns(outDir, instance, resumeState)
+			}
+
 			results, err := provisionAndExec(ctx, &suiteRun)
 			if err != nil {
 				log.Errorf("ERROR: %v", err)
 				unwrapStderr(err)
 			}
+			if control != nil {
+				control.close()
+			}
+			suiteRun.cleanupFailures.logSummary()
 
-			if err := saveResultsJSON(suiteRun, start, results); err != nil {
+			if err := saveResultsJSON(suiteRun, suiteRun.startTime, results); err != nil {
 				log.Warnf("Failed to save JSON results: %v", err)
 			}
 
+			if err := saveJUnitXML(suiteRun, results); err != nil {
+				log.Warnf("Failed to save JUnit XML results: %v", err)
+			}
+
+			if err := saveHTMLReport(suiteRun, suiteRun.startTime, results); err != nil {
+				log.Warnf("Failed to save HTML report: %v", err)
+			}
+
 			exitCode := printSummaryTable(suiteRun, results)
+			unregister()
 
 			log.Infoln("OVERALL EXECUTIONTIME:", time.Since(start).Round(time.Second))
 			os.Exit(exitCode)
@@ -304,6 +523,7 @@ current user.`,
 	rootCmd.Flags().StringVarP(&vmSpecPath, "vms", "", "vms.toml", "File containing VM specification")
 	rootCmd.Flags().StringVarP(&testSpecPath, "tests", "", "tests.toml", "File containing test specification")
 	rootCmd.Flags().StringArrayVarP(&provisionOverrides, "set", "s", []string{}, "set/override provisioning steps, for example '--set values.X=y'")
+	rootCmd.Flags().StringArrayVar(&secretOverrides, "secret-set", []string{}, "Like --set, but the value is masked as *** in logs and reports, for example '--secret-set values.TOKEN=secret'")
 	rootCmd.Flags().StringSliceVarP(&baseImages, "base-image", "", []string{}, "VM base images to use (defaults to all)")
 	rootCmd.Flags().StringSliceVarP(&excludeBaseImages, "exclude-base-image", "", []string{}, "VM base images to exclude (defaults to none)")
 	rootCmd.Flags().StringVarP(&toRun, "torun", "", "all", "comma separated list of test names to execute ('all' is a reserved test name)")
@@ -322,6 +542,28 @@ current user.`,
 	rootCmd.Flags().StringVarP(&firstv6Subnet, "first-v6-subnet", "", "fd62:a80c:412::/64", "The first ipv6 subnet to use for VMs. If more virtual networks are required, the next higher network of the same size will be used")
 	rootCmd.Flags().VarP(&pullImageTemplate, "pull-template", "", "Where to pull the base images from. Accepts a go template string, allowing usage like 'registry.example.com/vm/{{ .Image }}:latest'")
 	rootCmd.Flags().DurationVar(&timeoutSoft, "timeout-soft", 0, "Soft timeout for the entire test suite. Running tests finish but no new tests start. 0 means disabled.")
+	rootCmd.Flags().BoolVar(&untilFailure, "until-failure", false, "Keep starting new runs of the selected tests until one fails. Implies '--on-failure terminate' unless 'keep-vms' is given")
+	rootCmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "Time budget for --until-failure. Running tests finish but no new tests start. 0 means no limit.")
+	rootCmd.Flags().VarP(&vmSelection, "vm-selection", "", "How to choose VMs which are not otherwise determined: random|balanced. 'balanced' prefers the least used VMs")
+	rootCmd.Flags().StringSliceVarP(&vmSelectionHistory, "vm-selection-history", "", []string{}, "results.json files from previous runs used to initialize VM usage for '--vm-selection balanced'")
+	rootCmd.Flags().BoolVar(&resume, "resume", false, "Resume an interrupted test suite run from the state file in --out-dir. The same specification files and test selection flags must be given")
+	rootCmd.Flags().StringVar(&instance, "instance", "", "Namespace for this vmshed instance. Prefixes the names of VMs, networks and images and allocates VM IDs and subnets that do not overlap with other instances on this host. The VM IDs start at --startvm or higher, the subnets are taken from blocks following --first-subnet and --first-v6-subnet")
+	rootCmd.Flags().StringVar(&registryDir, "registry-dir", "/run/lock/vmshed", "Directory for the host-wide registry of vmshed instances. Runs register when --instance or --registry-dir is given")
+	rootCmd.Flags().BoolVar(&fill, "fill", false, "Once all planned runs have started, keep adding runs with randomly chosen VMs, taking the tests in turn, until --timeout-soft is reached")
+
+	rootCmd.Flags().StringVar(&quarantinePath, "quarantine", "", "TOML file with further expected_failures entries. Failures of matching runs are reported as XFAIL and do not affect the exit code")
+	rootCmd.Flags().StringVar(&controlSocket, "control-socket", "", "Path of a Unix socket to manage the running test suite with 'vmshed control'")
+	rootCmd.Flags().BoolVar(&pauseOnFailure, "pause-on-failure", false, "When a run fails, keep its VMs and IDs and print how to connect to them. Other runs continue. Press Enter or send SIGUSR2 to resume and remove the VMs")
+	rootCmd.Flags().StringToIntVar(&exitCodeOverrides, "exit-code", map[string]int{}, "Exit code for an outcome class as class=code. The classes are failure, error, skipped by --timeout-soft and canceled by a signal or control command. By default, skipped runs are ignored and the other classes give 1. If several occur, the first in the order failure, error, canceled, skipped applies. 0 ignores the class")
+	rootCmd.Flags().Float64Var(&successThreshold, "success-threshold", 100, "Percentage of successful runs at or above which failures do not affect the exit code")
+	rootCmd.Flags().StringSliceVar(&follow, "follow", []string{}, "Show the output of the runs of these tests on the console as it arrives, prefixed with the test ID. Accepts test names and test IDs")
+	rootCmd.Flags().DurationVar(&terminationGracePeriod, "termination-grace-period", defaultTerminationGracePeriod, "How long commands are given to exit after SIGTERM when vmshed is interrupted or a test times out, before they are killed. A second interrupt kills them immediately")
+	rootCmd.Flags().IntVar(&maxNetworks, "max-networks", 0, "Maximum number of virtual networks to exist at the same time. Idle extra networks which no pending test needs are removed to make room. 0 means no limit")
+
+	rootCmd.AddCommand(cleanupCommand())
+	rootCmd.AddCommand(compareCommand())
+	rootCmd.AddCommand(controlCommand())
+	rootCmd.AddCommand(historyCommand())
 	return rootCmd
 }
 
@@ -332,7 +574,9 @@ func createTestSuiteRun(
 	toRun string,
 	outDir string,
 	repeats int,
-	variantsToRun []string) (testSuiteRun, error) {
+	variantsToRun []string,
+	vmUsage map[string]int,
+	resumeState *savedState) (testSuiteRun, error) {
 
 	for testName := range testSpec.Tests {
 		if toRun != "all" && toRun != "" { //filter tests to Run
@@ -343,15 +587,41 @@ func createTestSuiteRun(
 	}
 
 	testSpec.Variants = filterVariants(testSpec.Variants, variantsToRun)
+	for _, v := range testSpec.Variants {
+		if v.IPv4 != nil && !*v.IPv4 && !v.IPv6 {
+			return testSuiteRun{}, fmt.Errorf("variant %s: ipv4 = false requires ipv6 = true", v.Name)
+		}
+	}
 
 	testLogDir := filepath.Join(outDir, "log")
-	testRuns, err := determineAllTestRuns(randomGenerator, testLogDir, &vmSpec, &testSpec, repeats)
+	testRuns, err := determineAllTestRuns(randomGenerator, testLogDir, &vmSpec, &testSpec, repeats, vmUsage)
 	if err != nil {
 		log.Fatal(err)
 	}
-	vmSpec.VMs = removeUnusedVMs(vmSpec.VMs, testRuns)
 
+	var resumedResults map[string]testResult
+	if resumeState != nil {
+		testRuns, resumedResults, err = restoreTestRuns(resumeState, testRuns, vmSpec.VMs, testSpec.Variants)
+		if err != nil {
+			return testSuiteRun{}, err
+		}
+		log.Infof("RESUME: %d of %d runs already done", len(resumedResults), len(testRuns))
+	}
+
+	pendingRuns := []testRun{}
 	for _, run := range testRuns {
+		if _, ok := resumedResults[run.testID]; ok {
+			continue
+		}
+		if run.disabled != "" {
+			log.Infof("DISABLED: %s: %s", run.testID, run.disabled)
+			continue
+		}
+		pendingRuns = append(pendingRuns, run)
+	}
+	vmSpec.VMs = removeUnusedVMs(vmSpec.VMs, pendingRuns)
+
+	for _, run := range pendingRuns {
 		images := make([]string, len(run.vms))
 		for i, v := range run.vms {
 			images[i] = v.ID()
@@ -361,37 +631,79 @@ func createTestSuiteRun(
 	}
 
 	suiteRun := testSuiteRun{
-		vmSpec:   &vmSpec,
-		testSpec: &testSpec,
-		outDir:   outDir,
-		testRuns: testRuns,
+		vmSpec:          &vmSpec,
+		testSpec:        &testSpec,
+		outDir:          outDir,
+		testRuns:        testRuns,
+		randomGenerator: randomGenerator,
+		resumedResults:  resumedResults,
 	}
 
 	return suiteRun, nil
 }
 
 func printSummaryTable(suiteRun testSuiteRun, results map[string]testResult) int {
-	exitCode := 0
 	success := 0
+	expectedFailures := 0
+	unexpectedSuccesses := 0
+	disabled := 0
+	notStarted := 0
 	runCount := 0
+	outcomes := map[OutcomeClass]int{}
 	// count successes
 	for _, testRun := range suiteRun.testRuns {
 		result, ok := results[testRun.testID]
 		if !ok {
+			// runs added by --until-failure or --fill are expected
+			// to be left over when the time is up
+			if !testRun.repeat {
+				outcomes[OutcomeSkipped]++
+			}
+			continue
+		}
+
+		if result.status == StatusSkipped && result.err == nil {
+			if testRun.disabled != "" {
+				disabled++
+			} else {
+				notStarted++
+			}
 			continue
 		}
 
 		runCount++
 
-		if result.err == nil {
+		switch {
+		case result.status == StatusXFail:
+			expectedFailures++
+		case result.status == StatusXPass:
+			unexpectedSuccesses++
 			success++
-		} else {
-			exitCode = 1
+		case result.err == nil:
+			success++
+		}
+		if class := resultOutcome(result); class != "" {
+			outcomes[class]++
 		}
 	}
-	successRate := (float32(success) / float32(runCount)) * 100
+	successRate := 100.0
+	if runCount > 0 {
+		successRate = float64(success) / float64(runCount) * 100
+	}
 	log.Infoln("|===================================================================================================")
 	log.Infof("| ** Results: %d/%d successful (%.2f%%)", success, runCount, successRate)
+	if expectedFailures > 0 || unexpectedSuccesses > 0 {
+		log.Infof("| ** %d expected failures (XFAIL), %d unexpected successes (XPASS)", expectedFailures, unexpectedSuccesses)
+	}
+	if disabled > 0 {
+		log.Infof("| ** %d runs of disabled tests skipped", disabled)
+	}
+	if notStarted > 0 {
+		log.Infof("| ** %d runs not started because of control commands", notStarted)
+	}
+	if outcomes[OutcomeSkipped] > 0 {
+		log.Infof("| ** %d runs not started before the soft timeout", outcomes[OutcomeSkipped])
+	}
 	log.Infoln("|===================================================================================================")
 	sortedTestRuns := suiteRun.testRuns
 	sort.SliceStable(sortedTestRuns, func(i, j int) bool {
@@ -403,6 +715,9 @@ func printSummaryTable(suiteRun testSuiteRun, results map[string]testResult) int
 			continue
 		}
 		log.Infof("| %-11s: %-73s : %9s", result.status, testRun.testID, result.execTime.Round(time.Second))
+		if result.note != "" {
+			log.Infof("| %-11s  %s", "", result.note)
+		}
 	}
 	log.Infoln("|===================================================================================================")
 	logViewer := getLogViewUrl("")
@@ -411,7 +726,14 @@ func printSummaryTable(suiteRun testSuiteRun, results map[string]testResult) int
 		log.Infoln("|===================================================================================================")
 	}
 
-	return exitCode
+	code, class := exitCode(suiteRun.exitCodes, outcomes, successRate, suiteRun.successThreshold)
+	if outcomes[OutcomeFailure] > 0 && class != OutcomeFailure && successRate >= suiteRun.successThreshold {
+		log.Infof("Success rate %.2f%% reaches --success-threshold, ignoring failures", successRate)
+	}
+	if code != 0 {
+		log.Infof("Exit code %d due to %s", code, class)
+	}
+	return code
 }
 
 func filterVariants(variants []variant, variantsToRun []string) []variant {
@@ -438,17 +760,32 @@ func determineAllTestRuns(
 	testLogDir string,
 	vmSpec *vmSpecification,
 	testSpec *testSpecification,
-	repeats int) ([]testRun, error) {
+	repeats int,
+	vmUsage map[string]int) ([]testRun, error) {
+
+	if testSpec.MaxParallel < 0 {
+		return nil, fmt.Errorf("max_parallel must not be negative")
+	}
 
 	testRuns := []testRun{}
 	for testName, test := range testSpec.Tests {
+		maxParallel := testSpec.MaxParallel
+		if test.MaxParallel != nil {
+			if *test.MaxParallel < 0 {
+				return nil, fmt.Errorf("test %s: max_parallel must not be negative", testName)
+			}
+			maxParallel = *test.MaxParallel
+		}
+
 		config := testConfig{
-			testLogDir: testLogDir,
-			vmSpec:     vmSpec,
-			testName:   testName,
-			test:       test,
-			repeats:    repeats,
-			networks:   append(testSpec.Networks, test.Networks...),
+			testLogDir:  testLogDir,
+			vmSpec:      vmSpec,
+			testName:    testName,
+			test:        test,
+			repeats:     repeats,
+			networks:    append(testSpec.Networks, test.Networks...),
+			maxParallel: maxParallel,
+			vmUsage:     vmUsage,
 		}
 		runs, err := determineRunsForTest(randomGenerator, &config, testSpec.Variants)
 		if err != nil {
@@ -462,6 +799,24 @@ func determineAllTestRuns(
 func determineRunsForTest(randomGenerator *rand.Rand, config *testConfig, variants []variant) ([]testRun, error) {
 	testRuns := []testRun{}
 
+	if config.test.Combinations != "" && config.test.Combinations != combinationsRandom &&
+		(config.test.SameVMs || config.test.NeedAllPlatforms) {
+		return nil, fmt.Errorf("test %s: combinations %q cannot be used together with samevms or needallplatforms",
+			config.testName, config.test.Combinations)
+	}
+
+	if err := validateNetworkAddresses(config.networks); err != nil {
+		return nil, fmt.Errorf("test %s: %w", config.testName, err)
+	}
+
+	if err := validateNICs(config); err != nil {
+		return nil, fmt.Errorf("test %s: %w", config.testName, err)
+	}
+
+	if err := validateNetworkEmulation(config); err != nil {
+		return nil, fmt.Errorf("test %s: %w", config.testName, err)
+	}
+
 	for _, variant := range variants {
 		// only add variants that are selected
 		if len(config.test.Variants) > 0 && !containsString(config.test.Variants, variant.Name) {
@@ -496,30 +851,79 @@ func determineRunsForTestVariant(randomGenerator *rand.Rand, config *testConfig,
 				testRuns = append(testRuns, newTestRun(
 					randomGenerator, config, testVariant, repeatVM(v, vmCount), len(testRuns), config.test.Variables))
 			}
-		} else if config.test.SameVMs {
-			v, err := randomVM(randomGenerator, availableVMs)
+		} else if config.test.Combinations == "" || config.test.Combinations == combinationsRandom {
+			vms, err := chooseVMs(randomGenerator, config, vmCount, availableVMs)
 			if err != nil {
 				return nil, err
 			}
 			testRuns = append(testRuns, newTestRun(
-				randomGenerator, config, testVariant, repeatVM(v, vmCount), len(testRuns), config.test.Variables))
+				randomGenerator, config, testVariant, vms, len(testRuns), config.test.Variables))
 		} else {
-			var vms []vm
-			for i := 0; i < vmCount; i++ {
-				v, err := randomVM(randomGenerator, availableVMs)
-				if err != nil {
-					return nil, err
-				}
-				vms = append(vms, v)
+			combinations, err := vmCombinations(randomGenerator, config.test.Combinations, availableVMs, vmCount)
+			if err != nil {
+				return nil, fmt.Errorf("test %s: %w", config.testName, err)
+			}
+			for _, vms := range combinations {
+				testRuns = append(testRuns, newTestRun(
+					randomGenerator, config, testVariant, vms, len(testRuns), config.test.Variables))
 			}
-			testRuns = append(testRuns, newTestRun(
-				randomGenerator, config, testVariant, vms, len(testRuns), config.test.Variables))
 		}
 	}
 
 	return testRuns, nil
 }
 
+// chooseVMs randomly selects the VMs for one run of a test that does not need
+// all platforms.
+func chooseVMs(randomGenerator *rand.Rand, config *testConfig, vmCount int, availableVMs []vm) ([]vm, error) {
+	if config.test.SameVMs {
+		v, err := selectVM(randomGenerator, config.vmUsage, availableVMs, nil)
+		if err != nil {
+			return nil, err
+		}
+		return repeatVM(v, vmCount), nil
+	}
+
+	var vms []vm
+	for i := 0; i < vmCount; i++ {
+		v, err := selectVM(randomGenerator, config.vmUsage, availableVMs, vms)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, v)
+	}
+	return vms, nil
+}
+
+// selectVM randomly chooses one of the VMs. If usage counts are given, only
+// the least used VMs are considered, including those already chosen for the
+// current run.
+func selectVM(randomGenerator *rand.Rand, usage map[string]int, vms []vm, chosen []vm) (vm, error) {
+	if usage == nil {
+		return randomVM(randomGenerator, vms)
+	}
+
+	leastUsed := []vm{}
+	minCount := 0
+	for _, v := range vms {
+		count := usage[v.ID()]
+		for _, c := range chosen {
+			if c.ID() == v.ID() {
+				count++
+			}
+		}
+
+		if len(leastUsed) == 0 || count < minCount {
+			leastUsed = []vm{v}
+			minCount = count
+		} else if count == minCount {
+			leastUsed = append(leastUsed, v)
+		}
+	}
+
+	return randomVM(randomGenerator, leastUsed)
+}
+
 func repeatVM(v vm, count int) []vm {
 	vms := make([]vm, count)
 	for i := 0; i < count; i++ {
@@ -566,25 +970,63 @@ func matchingVMTags(requiredVMTags []string, vms []vm) []vm {
 func newTestRun(randomGenerator *rand.Rand, config *testConfig, variant variant, vms []vm, testIndex int, variables map[string]string) testRun {
 	testID := testIDString(config.testName, len(vms), variant.Name, testIndex)
 
+	if config.vmUsage != nil {
+		for _, v := range vms {
+			config.vmUsage[v.ID()]++
+		}
+	}
+
 	run := testRun{
-		testName:  config.testName,
-		testID:    testID,
+		testName: config.testName,
+		testID:   testID,
 		// Give each test run a random priority to avoid favoring one
 		// test over another when they are otherwise equal for the
 		// scheduler. This is especially relevant when --timeout-soft
 		// is used so that tests are not excluded just because they are
 		// listed later.
-		priority:  randomGenerator.Uint64(),
-		outDir:    filepath.Join(config.testLogDir, testID),
-		vms:       vms,
-		networks:  config.networks,
-		variant:   variant,
-		variables: variables,
+		priority:      randomGenerator.Uint64(),
+		outDir:        filepath.Join(config.testLogDir, testID),
+		vms:           vms,
+		networks:      config.networks,
+		networkEvents: config.test.NetworkEvents,
+		variant:       variant,
+		variables:     variables,
+		maxParallel:   config.maxParallel,
+		disabled:      config.test.Disabled,
+		config:        config,
 	}
 
 	return run
 }
 
+// repeatTestRun creates another run with the same test, VM count and variant
+// as the given run. The VMs are chosen afresh unless the run covers a specific
+// platform.
+func repeatTestRun(randomGenerator *rand.Rand, testRuns []testRun, run *testRun) (testRun, error) {
+	vms := run.vms
+	if !run.config.test.NeedAllPlatforms {
+		variantVMs := matchingVMTags(run.variant.VMTags, run.config.vmSpec.VMs)
+		availableVMs := matchingVMTags(run.config.test.VMTags, variantVMs)
+
+		var err error
+		vms, err = chooseVMs(randomGenerator, run.config, len(run.vms), availableVMs)
+		if err != nil {
+			return testRun{}, err
+		}
+	}
+
+	testIndex := 0
+	for _, other := range testRuns {
+		if other.testName == run.testName && len(other.vms) == len(run.vms) && other.variant.Name == run.variant.Name {
+			testIndex++
+		}
+	}
+
+	repeated := newTestRun(randomGenerator, run.config, run.variant, vms, testIndex, run.variables)
+	repeated.repeat = true
+	return repeated, nil
+}
+
 func provisionAndExec(ctx context.Context, suiteRun *testSuiteRun) (map[string]testResult, error) {
 	// Note: When virter first starts it generates a key pair. However,
 	// when we start multiple instances concurrently, they race. The result
@@ -598,7 +1040,11 @@ func provisionAndExec(ctx context.Context, suiteRun *testSuiteRun) (map[string]t
 		return map[string]testResult{}, fmt.Errorf("cannot initialize virter: %w", err)
 	}
 
-	defer removeImages(suiteRun.outDir, suiteRun.vmSpec)
+	defer func() {
+		for _, err := range removeImages(suiteRun.outDir, suiteRun.vmSpec) {
+			suiteRun.cleanupFailures.add(err)
+		}
+	}()
 
 	results := runScheduler(ctx, suiteRun)
 	return results, nil
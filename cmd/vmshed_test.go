@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -230,6 +232,24 @@ func TestDeterminedTests(t *testing.T) {
 				"test_list_commands-1-etcd-1":    []string{"ubuntu-focal-linstor-k40"},
 			},
 		},
+		{
+			name:   "combinationsAll",
+			vmSpec: vmSpecToml,
+			testSpec: `test_suite_file = "run.toml"
+
+			[tests]
+			[tests.test_zfs_pair]
+			vms = [2]
+			vm_tags = ['zfs']
+			combinations = "all"`,
+			repeats: 1,
+			testIds: testSchedules{
+				"test_zfs_pair-2-default-0": []string{"ubuntu-bionic-linstor-k109"},
+				"test_zfs_pair-2-default-1": []string{"ubuntu-bionic-linstor-k109", "ubuntu-focal-linstor-k40"},
+				"test_zfs_pair-2-default-2": []string{"ubuntu-bionic-linstor-k109", "ubuntu-focal-linstor-k40"},
+				"test_zfs_pair-2-default-3": []string{"ubuntu-focal-linstor-k40"},
+			},
+		},
 	}
 
 	for _, test := range testCases {
@@ -251,7 +271,7 @@ func TestDeterminedTests(t *testing.T) {
 
 			testSuiteRun, err := createTestSuiteRun(
 				rand.New(rand.NewSource(12345678)),
-				vmSpec, testSpec, test.toRun, "", test.repeats, test.variants)
+				vmSpec, testSpec, test.toRun, "", test.repeats, test.variants, nil, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -360,3 +380,90 @@ func TestFilterVMs(t *testing.T) {
 		})
 	}
 }
+
+func TestBalancedVMSelection(t *testing.T) {
+	var vmSpec vmSpecification
+	if _, err := toml.Decode(vmSpecToml, &vmSpec); err != nil {
+		t.Fatal(err)
+	}
+
+	testSpec := testSpecification{
+		Tests: map[string]test{
+			"test_list_commands": {VMCount: []int{2}},
+		},
+	}
+
+	history := filepath.Join(t.TempDir(), "results.json")
+	historyJSON := `{"id":"a-1-default-0","base_images":["centos-8-linstor-k193"]}
+{"id":"a-1-default-1","base_images":["centos-8-linstor-k193"]}
+`
+	require.NoError(t, os.WriteFile(history, []byte(historyJSON), 0644))
+	vmUsage, err := loadVMUsage([]string{history})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"centos-8-linstor-k193": 2}, vmUsage)
+
+	suiteRun, err := createTestSuiteRun(
+		rand.New(rand.NewSource(12345678)),
+		vmSpec, testSpec, "all", "", 3, []string{}, vmUsage, nil)
+	require.NoError(t, err)
+	require.Len(t, suiteRun.testRuns, 3)
+
+	count := map[string]int{}
+	for _, run := range suiteRun.testRuns {
+		for _, v := range run.vms {
+			count[v.ID()]++
+		}
+	}
+
+	// 6 VMs are chosen in total, the image that was used before catches up
+	require.Equal(t, map[string]int{
+		"ubuntu-xenial-linstor-k185": 2,
+		"ubuntu-bionic-linstor-k109": 2,
+		"ubuntu-focal-linstor-k40":   2,
+	}, count)
+}
+
+func TestMaxParallel(t *testing.T) {
+	var vmSpec vmSpecification
+	if _, err := toml.Decode(vmSpecToml, &vmSpec); err != nil {
+		t.Fatal(err)
+	}
+
+	var testSpec testSpecification
+	_, err := toml.Decode(`
+max_parallel = 2
+
+[[variants]]
+name = "default"
+
+[tests.default]
+vms = [1]
+
+[tests.limited]
+vms = [1]
+max_parallel = 1
+
+[tests.unlimited]
+vms = [1]
+max_parallel = 0
+`, &testSpec)
+	require.NoError(t, err)
+
+	testRuns, err := determineAllTestRuns(rand.New(rand.NewSource(1)), "", &vmSpec, &testSpec, 1, nil)
+	require.NoError(t, err)
+	maxParallel := map[string]int{}
+	for _, run := range testRuns {
+		maxParallel[run.testName] = run.maxParallel
+	}
+	require.Equal(t, map[string]int{"default": 2, "limited": 1, "unlimited": 0}, maxParallel)
+
+	negative := -1
+	testSpec.Tests["limited"] = test{VMCount: []int{1}, MaxParallel: &negative}
+	_, err = determineAllTestRuns(rand.New(rand.NewSource(1)), "", &vmSpec, &testSpec, 1, nil)
+	require.Error(t, err)
+
+	testSpec.Tests["limited"] = test{VMCount: []int{1}}
+	testSpec.MaxParallel = -1
+	_, err = determineAllTestRuns(rand.New(rand.NewSource(1)), "", &vmSpec, &testSpec, 1, nil)
+	require.Error(t, err)
+}
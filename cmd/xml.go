@@ -1,47 +1,218 @@
 package cmd
 
 import (
+	"encoding/xml"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
-func XMLLog(resultsDir, testName string, testRes TestResulter, testLog []byte) error {
-	// Used to remove invalid runes from the output.
-	re, err := regexp.Compile("[^\t\n\r\x20-\x7e]")
+const junitTimestampFormat = "2006-01-02T15:04:05"
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr,omitempty"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr,omitempty"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+	Error      *junitMessage   `xml:"error,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func writeXMLFile(filename string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
 
+	data = append([]byte(xml.Header), data...)
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// XMLLog writes the JUnit XML file for a single test run.
+func XMLLog(resultsDir, testName string, testRes TestResulter, testLog []byte) error {
 	if err := os.MkdirAll(resultsDir, 0755); err != nil {
 		return err
 	}
 
-	f, err := os.Create(filepath.Join(resultsDir, testName+".xml"))
-	if err != nil {
-		return err
+	testCase := junitTestCase{
+		Name:      testName + ".run",
+		Classname: "test." + testName,
+		Time:      fmt.Sprintf("%.2f", testRes.ExecTime().Seconds()),
+		SystemOut: string(testLog),
 	}
-	defer f.Close()
 
-	var nrFailed int
+	suite := junitTestSuite{Tests: 1}
 	if testRes.Err() != nil {
-		nrFailed = 1 // currently there is only one test per execution
-	}
-	// header := fmt.Sprintf("<?xml version=\"1.0\" encoding=\"UTF-8\"?><testsuite tests=\"1\" failures=\"0\" errors=\"%d\">\n", status)
-	header := fmt.Sprintf("<testsuite tests=\"1\" failures=\"%d\" assertions=\"1\">\n", nrFailed)
-	header += fmt.Sprintf("<testcase classname=\"test.%s\" name=\"%s.run\" time=\"%.2f\">", testName, testName, testRes.ExecTime().Seconds())
-	header += "<system-out>\n<![CDATA[\n"
-	f.WriteString(header)
-	f.Write(re.ReplaceAllLiteral(testLog, []byte{' '}))
-	f.WriteString("]]></system-out>\n")
-	if nrFailed > 0 {
-		f.WriteString("<failure message=\"" + testRes.Err().Error() + "\">\n")
-		f.WriteString("<![CDATA[\n")
-		f.Write(re.ReplaceAllLiteral(testLog, []byte{' '}))
-		f.WriteString("]]>\n</failure>\n")
-	}
-	f.WriteString("</testcase></testsuite>")
+		suite.Failures = 1
+		testCase.Failure = &junitMessage{Message: secrets.mask(testRes.Err().Error()), Text: string(testLog)}
+	}
+	suite.TestCases = []junitTestCase{testCase}
+
+	return writeXMLFile(filepath.Join(resultsDir, testName+".xml"), &suite)
+}
 
+// saveJUnitXML writes a JUnit XML file for the whole test suite run with one
+// test suite for each test.
+func saveJUnitXML(suiteRun testSuiteRun, results map[string]testResult) error {
+	filename := filepath.Join(suiteRun.outDir, "junit.xml")
+	log.Infof("Saving results as JUnit XML to %s", filename)
+
+	report := junitReport(suiteRun, results)
+	if err := writeXMLFile(filename, report); err != nil {
+		return fmt.Errorf("failed to write JUnit XML file: %w", err)
+	}
 	return nil
 }
+
+func junitReport(suiteRun testSuiteRun, results map[string]testResult) *junitTestSuites {
+	suites := map[string]*junitTestSuite{}
+	suiteStart := map[string]time.Time{}
+	suiteTime := map[string]time.Duration{}
+	var totalTime time.Duration
+
+	for _, run := range suiteRun.testRuns {
+		res, ok := results[run.testID]
+		if !ok && run.repeat {
+			// runs added by --until-failure or --fill are expected
+			// to be left over when the time is up
+			continue
+		}
+		if !ok {
+			res = testResult{status: StatusSkipped}
+		}
+
+		suite, ok := suites[run.testName]
+		if !ok {
+			suite = &junitTestSuite{
+				Name: run.testName,
+				Properties: []junitProperty{
+					{Name: "seed", Value: strconv.FormatInt(suiteRun.seed, 10)},
+				},
+			}
+			suites[run.testName] = suite
+		}
+
+		testCase := junitTestCase{
+			Name:      run.testID,
+			Classname: "test." + run.testName,
+			Time:      junitSeconds(res.execTime),
+			Properties: []junitProperty{
+				{Name: "variant", Value: run.variant.Name},
+				{Name: "vm_count", Value: strconv.Itoa(len(run.vms))},
+				{Name: "base_images", Value: strings.Join(baseImageNames(run.vms), ",")},
+			},
+			SystemOut: res.testLog.String(),
+		}
+		if res.note != "" {
+			testCase.Properties = append(testCase.Properties, junitProperty{Name: "note", Value: secrets.mask(res.note)})
+		}
+		if !res.startTime.IsZero() {
+			testCase.Timestamp = res.startTime.UTC().Format(junitTimestampFormat)
+			if start, ok := suiteStart[run.testName]; !ok || res.startTime.Before(start) {
+				suiteStart[run.testName] = res.startTime
+			}
+		}
+
+		message := string(res.status)
+		if res.err != nil {
+			message = res.err.Error()
+		}
+		if res.note != "" {
+			message = res.note
+		}
+		message = secrets.mask(message)
+
+		switch res.status {
+		case StatusSuccess, StatusXPass:
+		case StatusXFail:
+			suite.Skipped++
+			testCase.Skipped = &junitMessage{Message: message}
+		case StatusFailed, StatusFailedTimeout:
+			suite.Failures++
+			testCase.Failure = &junitMessage{Message: message, Text: res.testLog.String()}
+		case StatusSkipped:
+			suite.Skipped++
+			testCase.Skipped = &junitMessage{Message: message}
+		default:
+			suite.Errors++
+			testCase.Error = &junitMessage{Message: message}
+		}
+
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+		suiteTime[run.testName] += res.execTime
+		totalTime += res.execTime
+	}
+
+	names := make([]string, 0, len(suites))
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &junitTestSuites{
+		Name: "vmshed",
+		Time: junitSeconds(totalTime),
+	}
+	for _, name := range names {
+		suite := suites[name]
+		suite.Time = junitSeconds(suiteTime[name])
+		if start, ok := suiteStart[name]; ok {
+			suite.Timestamp = start.UTC().Format(junitTimestampFormat)
+		}
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, *suite)
+	}
+	return report
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJUnitReport(t *testing.T) {
+	vmA := vm{BaseImage: "a"}
+	vmB := vm{BaseImage: "b"}
+	suiteRun := testSuiteRun{
+		seed: 42,
+		testRuns: []testRun{
+			{testName: "t2", testID: "t2-1-v-0", vms: []vm{vmA}, variant: variant{Name: "v"}},
+			{testName: "t1", testID: "t1-2-v-0", vms: []vm{vmA, vmB}, variant: variant{Name: "v"}},
+			{testName: "t1", testID: "t1-2-v-1", vms: []vm{vmB, vmB}, variant: variant{Name: "v"}},
+			{testName: "t1", testID: "t1-2-v-2", vms: []vm{vmB, vmA}, variant: variant{Name: "v"}},
+			// pending run added by --fill, not reported
+			{testName: "t1", testID: "t1-2-v-3", vms: []vm{vmB, vmA}, variant: variant{Name: "v"}, repeat: true},
+		},
+	}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	failed := testResult{status: StatusFailed, err: errors.New(`exit "1" <&>`), execTime: time.Second, startTime: start}
+	failed.testLog.WriteString("log\x00line")
+	results := map[string]testResult{
+		"t2-1-v-0": {status: StatusSuccess, execTime: 2 * time.Second, startTime: start.Add(time.Minute)},
+		"t1-2-v-0": failed,
+		"t1-2-v-1": {status: StatusCanceled, err: errors.New("canceled")},
+	}
+
+	report := junitReport(suiteRun, results)
+	assert.Equal(t, 4, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 1, report.Errors)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, "3.000", report.Time)
+
+	require.Len(t, report.Suites, 2)
+	t1 := report.Suites[0]
+	assert.Equal(t, "t1", t1.Name)
+	assert.Equal(t, 3, t1.Tests)
+	assert.Equal(t, "2024-01-02T03:04:05", t1.Timestamp)
+	assert.Equal(t, []junitProperty{{Name: "seed", Value: "42"}}, t1.Properties)
+
+	require.Len(t, t1.TestCases, 3)
+	assert.Equal(t, []junitProperty{
+		{Name: "variant", Value: "v"},
+		{Name: "vm_count", Value: "2"},
+		{Name: "base_images", Value: "a,b"},
+	}, t1.TestCases[0].Properties)
+	require.NotNil(t, t1.TestCases[0].Failure)
+	assert.Equal(t, `exit "1" <&>`, t1.TestCases[0].Failure.Message)
+	require.NotNil(t, t1.TestCases[1].Error)
+	require.NotNil(t, t1.TestCases[2].Skipped)
+
+	// The written file must be valid XML despite special characters
+	suiteRun.outDir = t.TempDir()
+	require.NoError(t, saveJUnitXML(suiteRun, results))
+	data, err := os.ReadFile(filepath.Join(suiteRun.outDir, "junit.xml"))
+	require.NoError(t, err)
+
+	var parsed junitTestSuites
+	require.NoError(t, xml.Unmarshal(data, &parsed))
+	assert.Equal(t, `exit "1" <&>`, parsed.Suites[0].TestCases[0].Failure.Message)
+	assert.Equal(t, "t2", parsed.Suites[1].Name)
+}
+
+func TestJUnitReportMasksNote(t *testing.T) {
+	saved := secrets
+	t.Cleanup(func() { secrets = saved })
+	secrets = newSecretMasker([]string{"token123"})
+
+	suiteRun := testSuiteRun{
+		testRuns: []testRun{{testName: "t", testID: "t-1-v-0", variant: variant{Name: "v"}}},
+	}
+	results := map[string]testResult{
+		"t-1-v-0": {status: StatusXFail, err: errors.New("failed"), note: "expected failure: token123"},
+	}
+
+	report := junitReport(suiteRun, results)
+	require.Len(t, report.Suites, 1)
+	require.Len(t, report.Suites[0].TestCases, 1)
+	testCase := report.Suites[0].TestCases[0]
+	assert.Contains(t, testCase.Properties, junitProperty{Name: "note", Value: "expected failure: ***"})
+	require.NotNil(t, testCase.Skipped)
+	assert.NotContains(t, testCase.Skipped.Message, "token123")
+}
@@ -3,12 +3,15 @@ package tests
 import (
 	_ "embed"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -47,14 +50,45 @@ var vmTagsTestsToml []byte
 //go:embed testdata/tests_many.toml
 var manyTestsToml []byte
 
+//go:embed testdata/tests_nics.toml
+var nicsTestsToml []byte
+
+//go:embed testdata/tests_ipv6_only.toml
+var ipv6OnlyTestsToml []byte
+
+//go:embed testdata/tests_expected_failures.toml
+var expectedFailuresTestsToml []byte
+
+//go:embed testdata/tests_disabled.toml
+var disabledTestsToml []byte
+
+//go:embed testdata/tests_fill.toml
+var fillTestsToml []byte
+
+//go:embed testdata/tests_secrets.toml
+var secretsTestsToml []byte
+
 type vmshedOpts struct {
 	VmsToml       []byte
 	TestsToml     []byte
 	VirterFailOn  string
 	VirterDelayOn string
 	VirterDelay   string
-	ExtraArgs     []string
-	ExitCode      int
+	// Written to stderr by the mock virter for VirterOutputOn
+	VirterOutputOn string
+	VirterOutput   string
+	ExtraArgs      []string
+	ExitCode       int
+	// Written to state.json in the output directory before running vmshed
+	StateJSON []byte
+	// Subcommand to run instead of running tests
+	Command string
+	// Standard input of vmshed
+	Stdin io.Reader
+	// The mock virter only stops on SIGKILL
+	VirterIgnoreSIGTERM bool
+	// Send SIGINT to vmshed after each of these delays from the start
+	Interrupts []time.Duration
 }
 
 type virterCall struct {
@@ -86,6 +120,7 @@ type testResult struct {
 	VMCount    int      `json:"vm_count"`
 	Variant    string   `json:"variant"`
 	BaseImages []string `json:"base_images"`
+	Note       string   `json:"note"`
 }
 
 type vmshedResult struct {
@@ -137,6 +172,10 @@ func runVmshed(t *testing.T, opts vmshedOpts) vmshedResult {
 	require.NoError(t, os.Symlink(mockVirterBinary(t), filepath.Join(binDir, "virter")))
 
 	outDir := filepath.Join(dir, "out")
+	if opts.StateJSON != nil {
+		require.NoError(t, os.Mkdir(outDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(outDir, "state.json"), opts.StateJSON, 0644))
+	}
 
 	args := []string{
 		"--vms", vmsToml,
@@ -146,6 +185,13 @@ func runVmshed(t *testing.T, opts vmshedOpts) vmshedResult {
 		"--startvm", "2",
 		"--seed", "1",
 	}
+	if opts.Command != "" {
+		args = []string{
+			opts.Command,
+			"--vms", vmsToml,
+			"--out-dir", outDir,
+		}
+	}
 	args = append(args, opts.ExtraArgs...)
 
 	virterLog := filepath.Join(dir, "virter.log")
@@ -160,13 +206,29 @@ func runVmshed(t *testing.T, opts vmshedOpts) vmshedResult {
 		cmd.Env = append(cmd.Env, "MOCK_VIRTER_DELAY_ON="+opts.VirterDelayOn)
 		cmd.Env = append(cmd.Env, "MOCK_VIRTER_DELAY="+opts.VirterDelay)
 	}
+	if opts.VirterOutputOn != "" {
+		cmd.Env = append(cmd.Env, "MOCK_VIRTER_OUTPUT_ON="+opts.VirterOutputOn)
+		cmd.Env = append(cmd.Env, "MOCK_VIRTER_OUTPUT="+opts.VirterOutput)
+	}
+	if opts.VirterIgnoreSIGTERM {
+		cmd.Env = append(cmd.Env, "MOCK_VIRTER_IGNORE_SIGTERM=1")
+	}
 	cmd.Dir = dir
+	cmd.Stdin = opts.Stdin
 
 	var stdout, stderr strings.Builder
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	require.NoError(t, cmd.Start())
+	go func(start time.Time) {
+		for _, delay := range opts.Interrupts {
+			time.Sleep(time.Until(start.Add(delay)))
+			cmd.Process.Signal(os.Interrupt)
+		}
+	}(time.Now())
+
+	err := cmd.Wait()
 
 	exitCode := 0
 	if err != nil {
@@ -551,3 +613,678 @@ func TestRandomOrder(t *testing.T) {
 	assert.NotEqual(t, sorted, execOrder,
 		"test execution order should be randomized, not sorted")
 }
+
+func TestUntilFailure(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:      defaultVmsToml,
+		TestsToml:    defaultTestsToml,
+		VirterFailOn: "vm exec",
+		ExtraArgs:    []string{"--until-failure"},
+		ExitCode:     1,
+	})
+
+	assert.Equal(t, 1, countSubcommand(res.VirterCalls, "vm exec"))
+	require.Len(t, res.Results, 1)
+	assert.Equal(t, "FAILED", res.Results[0].Status)
+	assert.Contains(t, res.Stderr, "0 successful iterations before failure of mytest-1-default-0")
+}
+
+func TestUntilFailureMaxDuration(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:       defaultVmsToml,
+		TestsToml:     defaultTestsToml,
+		VirterDelayOn: "vm exec",
+		VirterDelay:   "100ms",
+		ExtraArgs:     []string{"--until-failure", "--max-duration", "1s"},
+		ExitCode:      0,
+	})
+
+	require.Greater(t, len(res.Results), 1, "expected the test to be repeated")
+	for i, r := range res.Results {
+		assert.Equal(t, "SUCCESS", r.Status)
+		assert.Equal(t, fmt.Sprintf("mytest-1-default-%d", i), r.ID)
+	}
+	assert.Contains(t, res.Stderr, fmt.Sprintf("%d successful iterations without failure", len(res.Results)))
+}
+
+func TestFill(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:       defaultVmsToml,
+		TestsToml:     twoTestsToml,
+		VirterDelayOn: "vm exec",
+		VirterDelay:   "100ms",
+		ExtraArgs:     []string{"--fill", "--timeout-soft", "1s"},
+		ExitCode:      0,
+	})
+
+	require.Greater(t, len(res.Results), 2, "expected additional runs")
+	for _, r := range res.Results {
+		assert.Equal(t, "SUCCESS", r.Status)
+	}
+
+	first := len(resultsByName(res.Results, "first"))
+	second := len(resultsByName(res.Results, "second"))
+	assert.InDelta(t, first, second, 1, "tests should be repeated in turn")
+}
+
+func TestFillUnequalRuns(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:       defaultVmsToml,
+		TestsToml:     fillTestsToml,
+		VirterDelayOn: "vm exec",
+		VirterDelay:   "100ms",
+		// "many" has 6 planned runs, "one" has 2
+		ExtraArgs: []string{"--fill", "--timeout-soft", "2s", "--repeats", "2"},
+		ExitCode:  0,
+	})
+
+	many := len(resultsByName(res.Results, "many")) - 6
+	one := len(resultsByName(res.Results, "one")) - 2
+	require.Greater(t, many+one, 2, "expected additional runs")
+	assert.InDelta(t, many, one, 1, "tests should be repeated in turn")
+}
+
+func TestStateFile(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: twoTestsToml,
+	})
+
+	data, err := os.ReadFile(filepath.Join(res.OutDir, "state.json"))
+	require.NoError(t, err)
+
+	var state struct {
+		Runs []struct {
+			ID     string `json:"id"`
+			Stage  string `json:"stage"`
+			Status string `json:"status"`
+		} `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(data, &state))
+	require.Len(t, state.Runs, 2)
+	for _, r := range state.Runs {
+		assert.Equal(t, "Done", r.Stage)
+		assert.Equal(t, "SUCCESS", r.Status)
+	}
+}
+
+func TestResume(t *testing.T) {
+	state := `{
+  "start_time": "2024-01-02T03:04:05Z",
+  "runs": [
+    {"id": "mytest-1-default-0", "name": "mytest", "variant": "default", "vms": ["testimage"], "stage": "Done", "status": "FAILED", "error": "exit status 1", "duration_ns": 1000},
+    {"id": "mytest-1-default-1", "name": "mytest", "variant": "default", "vms": ["testimage"], "stage": "Exec", "ids": [2], "networks": ["vmshed-0-access"]},
+    {"id": "mytest-1-default-2", "name": "mytest", "variant": "default", "vms": ["testimage"], "stage": "New"}
+  ],
+  "networks": ["vmshed-0-access"],
+  "provisioning": [{"vm": "testimage", "image": "testimage-prov", "id": 3, "network": "vmshed-0-access"}]
+}`
+
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: defaultTestsToml,
+		ExtraArgs: []string{"--resume"},
+		StateJSON: []byte(state),
+		// the failure from before the interruption is kept
+		ExitCode: 1,
+	})
+
+	subcmds := subcommands(res.VirterCalls)
+	require.GreaterOrEqual(t, len(subcmds), 3)
+	assert.Equal(t, []string{"vm rm", "vm rm", "network rm"}, subcmds[:3], "interrupted run should be cleaned up first")
+	assert.Equal(t, []string{"rm", "lbtest-vm-2"}, res.VirterCalls[0].Args[1:])
+	assert.Equal(t, []string{"rm", "testimage-prov"}, res.VirterCalls[1].Args[1:], "provisioning VM should be removed")
+	assert.Equal(t, 2, countSubcommand(res.VirterCalls, "vm exec"))
+
+	require.Len(t, res.Results, 3)
+	assert.Equal(t, "FAILED", res.Results[0].Status)
+	assert.Equal(t, "SUCCESS", res.Results[1].Status)
+	assert.Equal(t, "SUCCESS", res.Results[2].Status)
+}
+
+func TestCleanup(t *testing.T) {
+	vmsToml := []byte(`name = "prov"
+provision_file = "provision.toml"
+
+[[vms]]
+base_image = "testimage"
+`)
+
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   vmsToml,
+		Command:   "cleanup",
+		ExtraArgs: []string{"--startvm", "5", "--nvms", "2", "--networks", "1"},
+	})
+
+	var calls []string
+	for _, c := range res.VirterCalls {
+		calls = append(calls, strings.Join(c.Args, " "))
+	}
+	assert.Equal(t, []string{
+		"vm rm lbtest-vm-5",
+		"vm rm lbtest-vm-6",
+		"network rm vmshed-0-access",
+		"network rm vmshed-0-extra",
+		"image rm testimage-prov",
+	}, calls)
+}
+
+func TestStateAfterTearDown(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: defaultTestsToml,
+	})
+
+	data, err := os.ReadFile(filepath.Join(res.OutDir, "state.json"))
+	require.NoError(t, err)
+	var state struct {
+		Networks []string `json:"networks"`
+	}
+	require.NoError(t, json.Unmarshal(data, &state))
+	assert.Empty(t, state.Networks, "removed networks should not be left in the state file")
+}
+
+func TestCleanupFromState(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:      defaultVmsToml,
+		Command:      "cleanup",
+		StateJSON:    []byte(`{"runs": [], "networks": ["vmshed-3-extra"], "provisioning": [{"vm": "testimage", "image": "testimage-prov", "id": 3, "network": "vmshed-3-access"}]}`),
+		VirterFailOn: "network rm",
+		ExtraArgs:    []string{"--nvms", "1"},
+		ExitCode:     1,
+	})
+
+	var calls []string
+	for _, c := range res.VirterCalls {
+		calls = append(calls, strings.Join(c.Args, " "))
+	}
+	assert.Equal(t, []string{"vm rm lbtest-vm-2", "vm rm testimage-prov", "network rm vmshed-3-extra"}, calls)
+	assert.Contains(t, res.Stderr, "remove network vmshed-3-extra")
+}
+
+func TestCleanupRegisteredInstance(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		Command:   "cleanup",
+		StateJSON: []byte(`{"runs": [], "networks": [], "instance": "ci1", "start_vm": 7, "nr_vms": 2}`),
+	})
+
+	var calls []string
+	for _, c := range res.VirterCalls {
+		calls = append(calls, strings.Join(c.Args, " "))
+	}
+	assert.Equal(t, []string{"vm rm ci1-lbtest-vm-7", "vm rm ci1-lbtest-vm-8"}, calls)
+}
+
+func TestResumeOtherInstance(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: defaultTestsToml,
+		ExtraArgs: []string{"--resume"},
+		StateJSON: []byte(`{"runs": [], "networks": [], "instance": "ci1", "start_vm": 7, "nr_vms": 1}`),
+		ExitCode:  1,
+	})
+
+	assert.Empty(t, res.VirterCalls)
+	assert.Contains(t, res.Stderr, "state file is from instance ci1")
+}
+
+func TestSubnetBlockExhausted(t *testing.T) {
+	var testsToml strings.Builder
+	testsToml.WriteString("test_suite_file = \"run.toml\"\n\n[tests.mytest]\nvms = [1]\n")
+	for i := 0; i < 32; i++ {
+		testsToml.WriteString("\n[[tests.mytest.networks]]\nforward = \"nat\"\ndhcp = true\n")
+	}
+
+	// Without registration, the subnets are not limited
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: []byte(testsToml.String()),
+	})
+	assert.Equal(t, 33, countSubcommand(res.VirterCalls, "network add"))
+	require.Len(t, res.Results, 1)
+
+	res = runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: []byte(testsToml.String()),
+		ExtraArgs: []string{"--registry-dir", t.TempDir()},
+		ExitCode:  1,
+	})
+
+	// The access network and 31 extra networks fit into the block
+	assert.Equal(t, 32, countSubcommand(res.VirterCalls, "network add"))
+	assert.Equal(t, 32, countSubcommand(res.VirterCalls, "network rm"))
+	assert.Equal(t, 0, countSubcommand(res.VirterCalls, "vm run"))
+	assert.Contains(t, res.Stderr, "no subnet left for this instance")
+}
+
+func TestInstance(t *testing.T) {
+	registryDir := t.TempDir()
+
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: defaultTestsToml,
+		ExtraArgs: []string{"--instance", "ci1", "--registry-dir", registryDir},
+	})
+
+	require.Len(t, res.Results, 1)
+	for _, c := range res.VirterCalls {
+		switch c.Subcommand() {
+		case "network add", "network rm":
+			assert.Equal(t, "ci1-vmshed-0-access", c.Args[2])
+		case "vm rm":
+			assert.Equal(t, "ci1-lbtest-vm-2", c.Args[2])
+		case "vm exec":
+			assert.Equal(t, "ci1-lbtest-vm-2", c.Args[len(c.Args)-1])
+		}
+	}
+
+	registry, err := os.ReadFile(filepath.Join(registryDir, "registry.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(registry), "instance should be unregistered at exit")
+}
+
+func TestNICAttachment(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: nicsTestsToml,
+		ExtraArgs: []string{"--nvms", "2"},
+	})
+
+	require.Len(t, res.Results, 1)
+	nics := map[string][]string{}
+	for _, c := range res.VirterCalls {
+		if c.Subcommand() != "vm run" {
+			continue
+		}
+
+		var id string
+		vmNICs := []string{}
+		for i, arg := range c.Args {
+			if i+1 >= len(c.Args) {
+				break
+			}
+			switch arg {
+			case "--id":
+				id = c.Args[i+1]
+			case "--nic":
+				vmNICs = append(vmNICs, c.Args[i+1])
+			}
+		}
+		nics[id] = vmNICs
+	}
+
+	assert.Equal(t, map[string][]string{
+		"2": {},
+		"3": {"type=network,source=vmshed-1-extra,mac=52:54:00:00:00:aa,mtu=9000"},
+	}, nics)
+}
+
+func TestMaxNetworksTooSmall(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: nicsTestsToml,
+		ExtraArgs: []string{"--nvms", "2", "--max-networks", "1"},
+		ExitCode:  1,
+	})
+
+	assert.Equal(t, 0, countSubcommand(res.VirterCalls, "network add"))
+	assert.Contains(t, res.Stderr, "but --max-networks is 1")
+}
+
+func TestIPv6OnlyNetwork(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: ipv6OnlyTestsToml,
+	})
+
+	require.Len(t, res.Results, 1)
+	var networkAdds [][]string
+	for _, c := range res.VirterCalls {
+		if c.Subcommand() == "network add" {
+			networkAdds = append(networkAdds, c.Args)
+		}
+	}
+
+	require.Len(t, networkAdds, 2)
+	assert.Equal(t, []string{"network", "add", "vmshed-1-extra",
+		"--dhcp", "--network-v6-cidr", "fd62:a80c:412::1/56", "--forward-mode", "nat"}, networkAdds[1])
+}
+
+func TestJUnitXML(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:      defaultVmsToml,
+		TestsToml:    twoTestsToml,
+		VirterFailOn: "vm exec",
+		ExitCode:     1,
+	})
+
+	data, err := os.ReadFile(filepath.Join(res.OutDir, "junit.xml"))
+	require.NoError(t, err)
+
+	var report struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Suites   []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"testsuite"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &report))
+	assert.Equal(t, len(res.Results), report.Tests)
+	assert.Equal(t, report.Tests, report.Failures)
+	assert.Len(t, report.Suites, 2)
+}
+
+func TestHTMLReport(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: twoTestsToml,
+	})
+
+	data, err := os.ReadFile(filepath.Join(res.OutDir, "report.html"))
+	require.NoError(t, err)
+	for _, r := range res.Results {
+		assert.Contains(t, string(data), r.ID)
+	}
+	assert.Contains(t, string(data), "log/"+res.Results[0].ID+"/test.log")
+}
+
+func TestExpectedFailures(t *testing.T) {
+	t.Run("xfail", func(t *testing.T) {
+		res := runVmshed(t, vmshedOpts{
+			VmsToml:      defaultVmsToml,
+			TestsToml:    expectedFailuresTestsToml,
+			VirterFailOn: "vm exec",
+			ExitCode:     1,
+		})
+
+		assert.Equal(t, "XFAIL", resultsByName(res.Results, "first")[0].Status)
+		assert.Equal(t, "FAILED", resultsByName(res.Results, "second")[0].Status)
+	})
+
+	t.Run("xpass", func(t *testing.T) {
+		res := runVmshed(t, vmshedOpts{
+			VmsToml:   defaultVmsToml,
+			TestsToml: expectedFailuresTestsToml,
+		})
+
+		assert.Equal(t, "XPASS", resultsByName(res.Results, "first")[0].Status)
+		assert.Equal(t, "SUCCESS", resultsByName(res.Results, "second")[0].Status)
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		quarantine := filepath.Join(t.TempDir(), "quarantine.toml")
+		require.NoError(t, os.WriteFile(quarantine, []byte(`
+[[expected_failures]]
+test = "second"
+reason = "flaky"
+`), 0644))
+
+		res := runVmshed(t, vmshedOpts{
+			VmsToml:      defaultVmsToml,
+			TestsToml:    expectedFailuresTestsToml,
+			VirterFailOn: "vm exec",
+			ExtraArgs:    []string{"--quarantine", quarantine},
+			ExitCode:     0,
+		})
+
+		require.Len(t, res.Results, 2)
+		for _, r := range res.Results {
+			assert.Equal(t, "XFAIL", r.Status, r.ID)
+		}
+		assert.Contains(t, res.Stderr, "2 expected failures (XFAIL)")
+	})
+}
+
+func TestDisabledTest(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:   defaultVmsToml,
+		TestsToml: disabledTestsToml,
+	})
+
+	for _, c := range res.VirterCalls {
+		assert.NotEqual(t, "first", c.TestName())
+	}
+
+	first := resultsByName(res.Results, "first")
+	require.Len(t, first, 1)
+	assert.Equal(t, "SKIPPED", first[0].Status)
+	assert.Equal(t, "disabled: waiting for fix", first[0].Note)
+	assert.Equal(t, "SUCCESS", resultsByName(res.Results, "second")[0].Status)
+	assert.Contains(t, res.Stderr, "disabled: waiting for fix")
+
+	data, err := os.ReadFile(filepath.Join(res.OutDir, "junit.xml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `<skipped message="disabled: waiting for fix">`)
+}
+
+func TestExitCodePolicy(t *testing.T) {
+	t.Run("override", func(t *testing.T) {
+		runVmshed(t, vmshedOpts{
+			VmsToml:      defaultVmsToml,
+			TestsToml:    twoTestsToml,
+			VirterFailOn: "vm exec",
+			ExtraArgs:    []string{"--exit-code", "failure=7"},
+			ExitCode:     7,
+		})
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		runVmshed(t, vmshedOpts{
+			VmsToml:   defaultVmsToml,
+			TestsToml: twoTestsToml,
+			ExtraArgs: []string{"--timeout-soft", "1ns", "--exit-code", "skipped=3"},
+			ExitCode:  3,
+		})
+	})
+
+	t.Run("failure before canceled", func(t *testing.T) {
+		runVmshed(t, vmshedOpts{
+			VmsToml:      defaultVmsToml,
+			TestsToml:    twoTestsToml,
+			VirterFailOn: "vm exec",
+			ExtraArgs:    []string{"--on-failure", "terminate", "--exit-code", "error=2,canceled=4"},
+			ExitCode:     1,
+		})
+	})
+
+	t.Run("success threshold", func(t *testing.T) {
+		res := runVmshed(t, vmshedOpts{
+			VmsToml:      defaultVmsToml,
+			TestsToml:    twoTestsToml,
+			VirterFailOn: "vm exec",
+			ExtraArgs:    []string{"--success-threshold", "0"},
+			ExitCode:     0,
+		})
+		assert.Contains(t, res.Stderr, "ignoring failures")
+	})
+}
+
+func TestPauseOnFailure(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "out")
+
+	// Press Enter once the failed run has paused. The logs of the run are
+	// written before it pauses.
+	var reports, xmlLogs []string
+	stdin, stdinWriter := io.Pipe()
+	go func() {
+		for i := 0; i < 100 && len(reports) == 0; i++ {
+			time.Sleep(50 * time.Millisecond)
+			reports, _ = filepath.Glob(filepath.Join(outDir, "log", "*", "report.log"))
+		}
+		xmlLogs, _ = filepath.Glob(filepath.Join(outDir, "test-results", "*.xml"))
+		time.Sleep(time.Second)
+		fmt.Fprintln(stdinWriter)
+		stdinWriter.Close()
+	}()
+
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:      defaultVmsToml,
+		TestsToml:    defaultTestsToml,
+		VirterFailOn: "vm exec",
+		ExtraArgs:    []string{"--pause-on-failure", "--out-dir", outDir},
+		Stdin:        stdin,
+		ExitCode:     1,
+	})
+
+	assert.Len(t, reports, 1, "report.log should be written before pausing")
+	assert.Len(t, xmlLogs, 1, "the JUnit XML file should be written before pausing")
+	if len(reports) == 1 {
+		report, err := os.ReadFile(reports[0])
+		require.NoError(t, err)
+		assert.Contains(t, string(report), "FINISH: VMs removed", "report.log should be rewritten after removing the VMs")
+	}
+	assert.Contains(t, res.Stderr, "PAUSE: Run ")
+	assert.Contains(t, res.Stderr, ":6002")
+	assert.Contains(t, res.Stderr, "PAUSE: Resuming")
+
+	// the VM is removed after resuming
+	calls := subcommands(res.VirterCalls)
+	lastExec := -1
+	for i, c := range calls {
+		if c == "vm exec" {
+			lastExec = i
+		}
+	}
+	require.GreaterOrEqual(t, lastExec, 0)
+	assert.Contains(t, calls[lastExec+1:], "vm rm")
+}
+
+func TestControlSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+
+	var listOutput string
+	var controlErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(socket); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		// wait until the first test is running
+		for i := 0; i < 100 && !strings.Contains(listOutput, "Exec"); i++ {
+			var out []byte
+			out, controlErr = exec.Command(vmshedBinary(t), "control", "--socket", socket, "list").Output()
+			if controlErr != nil {
+				return
+			}
+			listOutput = string(out)
+			time.Sleep(50 * time.Millisecond)
+		}
+		controlErr = exec.Command(vmshedBinary(t), "control", "--socket", socket, "stop").Run()
+	}()
+
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:       defaultVmsToml,
+		TestsToml:     twoTestsToml,
+		VirterDelayOn: "vm exec",
+		VirterDelay:   "2s",
+		ExtraArgs:     []string{"--control-socket", socket},
+		ExitCode:      0,
+	})
+	<-done
+
+	require.NoError(t, controlErr)
+	assert.Contains(t, listOutput, "RUN")
+	assert.Contains(t, listOutput, "Exec")
+	assert.Equal(t, 1, countSubcommand(res.VirterCalls, "vm exec"))
+	require.Len(t, res.Results, 1)
+	assert.Equal(t, "SUCCESS", res.Results[0].Status)
+	assert.Contains(t, res.Stderr, "1 runs not started because of control commands")
+	assert.NotContains(t, res.Stderr, "not started before the soft timeout")
+}
+
+func TestInterruptTwice(t *testing.T) {
+	start := time.Now()
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:             defaultVmsToml,
+		TestsToml:           defaultTestsToml,
+		VirterDelayOn:       "vm exec",
+		VirterDelay:         "60s",
+		VirterIgnoreSIGTERM: true,
+		ExtraArgs:           []string{"--termination-grace-period", "1h", "--exit-code", "canceled=4"},
+		Interrupts:          []time.Duration{time.Second, 2 * time.Second},
+		ExitCode:            4,
+	})
+
+	// the test is killed without waiting for the grace period
+	assert.Less(t, time.Since(start), 30*time.Second)
+	assert.Contains(t, res.Stderr, "Interrupted again, send SIGKILL")
+
+	// cleanup still runs after the second interrupt
+	calls := subcommands(res.VirterCalls)
+	lastExec := -1
+	for i, c := range calls {
+		if c == "vm exec" {
+			lastExec = i
+		}
+	}
+	require.GreaterOrEqual(t, lastExec, 0)
+	assert.Contains(t, calls[lastExec+1:], "vm rm")
+	assert.Contains(t, calls[lastExec+1:], "network rm")
+	assert.NotContains(t, res.Stderr, "CLEANUP:")
+}
+
+func TestFollowTestOutput(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:        defaultVmsToml,
+		TestsToml:      twoTestsToml,
+		VirterOutputOn: "vm exec",
+		VirterOutput:   "step 1 done\nstep 2",
+		ExtraArgs:      []string{"--follow", "first"},
+	})
+
+	assert.Contains(t, res.Stderr, "[first-1-default-0] step 1 done\n[first-1-default-0] step 2\n")
+	assert.NotContains(t, res.Stderr, "[second-1-default-0]")
+
+	testLog, err := os.ReadFile(filepath.Join(res.OutDir, "log", "second-1-default-0", "test.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "step 1 done\nstep 2", string(testLog))
+}
+
+func TestSecretMasking(t *testing.T) {
+	res := runVmshed(t, vmshedOpts{
+		VmsToml:        defaultVmsToml,
+		TestsToml:      secretsTestsToml,
+		VirterOutputOn: "vm exec",
+		VirterOutput:   "using variant-token-123 and flag-secret-456\n",
+		VirterFailOn:   "vm exec",
+		ExtraArgs:      []string{"--secret-set", "values.PASSWORD=flag-secret-456", "--follow", "mytest"},
+		ExitCode:       1,
+	})
+
+	// the values are passed to virter
+	var execCall virterCall
+	for _, c := range res.VirterCalls {
+		if c.Subcommand() == "vm exec" {
+			execCall = c
+		}
+	}
+	assert.Contains(t, execCall.Args, "values.TOKEN=variant-token-123")
+	assert.Contains(t, execCall.Args, "values.PASSWORD=flag-secret-456")
+
+	// but not written anywhere
+	assert.Contains(t, res.Stderr, "values.TOKEN=***")
+	assert.Contains(t, res.Stderr, "values.PLAIN=visible-value")
+	err := filepath.WalkDir(res.OutDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		for _, secret := range []string{"variant-token-123", "flag-secret-456"} {
+			assert.NotContains(t, string(data), secret, "secret in %s", path)
+			assert.NotContains(t, res.Stderr, secret)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	testLog, err := os.ReadFile(filepath.Join(res.OutDir, "log", "mytest-1-default-0", "test.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(testLog), "using *** and ***\n")
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -12,6 +14,10 @@ type invocation struct {
 }
 
 func main() {
+	if os.Getenv("MOCK_VIRTER_IGNORE_SIGTERM") != "" {
+		signal.Ignore(syscall.SIGTERM)
+	}
+
 	logPath := os.Getenv("MOCK_VIRTER_LOG")
 	if logPath != "" {
 		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -29,6 +35,11 @@ func main() {
 
 	subcmd := os.Args[1] + " " + os.Args[2]
 
+	outputOn := os.Getenv("MOCK_VIRTER_OUTPUT_ON")
+	if outputOn != "" && subcmd == outputOn {
+		fmt.Fprint(os.Stderr, os.Getenv("MOCK_VIRTER_OUTPUT"))
+	}
+
 	delayOn := os.Getenv("MOCK_VIRTER_DELAY_ON")
 	if delayOn != "" && subcmd == delayOn {
 		d, err := time.ParseDuration(os.Getenv("MOCK_VIRTER_DELAY"))